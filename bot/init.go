@@ -13,6 +13,7 @@ import (
 	"maunium.net/go/mautrix/appservice"
 	"maunium.net/go/mautrix/crypto"
 	"maunium.net/go/mautrix/crypto/cryptohelper"
+	"maunium.net/go/mautrix/synapseadmin"
 
 	"go.mau.fi/meowlnir/database"
 )
@@ -23,6 +24,8 @@ type Bot struct {
 	*mautrix.Client
 	Intent *appservice.IntentAPI
 
+	SynapseAdmin *synapseadmin.Client
+
 	CryptoStore  *crypto.SQLCryptoStore
 	CryptoHelper *cryptohelper.CryptoHelper
 	Mach         *crypto.OlmMachine
@@ -66,6 +69,8 @@ func NewBot(
 		Intent: intent,
 		Log:    log,
 
+		SynapseAdmin: &synapseadmin.Client{Client: client},
+
 		CryptoStore:  cryptoStore,
 		CryptoHelper: helper,
 
@@ -21,9 +21,13 @@ type SendNoticeOpts struct {
 	DisallowMarkdown bool
 	AllowHTML        bool
 	Mentions         *event.Mentions
+	RelatesTo        *event.RelatesTo
+	// Edit is the ID of a previous notice that this notice should replace.
+	Edit id.EventID
 }
 
-func (bot *Bot) SendNoticeOpts(ctx context.Context, roomID id.RoomID, message string, opts *SendNoticeOpts) {
+// SendNoticeOpts sends a notice with the given options and returns the event ID, or an empty string if sending failed.
+func (bot *Bot) SendNoticeOpts(ctx context.Context, roomID id.RoomID, message string, opts *SendNoticeOpts) id.EventID {
 	if opts == nil {
 		opts = &SendNoticeOpts{}
 	}
@@ -32,9 +36,15 @@ func (bot *Bot) SendNoticeOpts(ctx context.Context, roomID id.RoomID, message st
 	if opts.Mentions != nil {
 		content.Mentions = opts.Mentions
 	}
-	_, err := bot.Client.SendMessageEvent(ctx, roomID, event.EventMessage, &content)
+	content.RelatesTo = opts.RelatesTo
+	if opts.Edit != "" {
+		content.SetEdit(opts.Edit)
+	}
+	resp, err := bot.Client.SendMessageEvent(ctx, roomID, event.EventMessage, &content)
 	if err != nil {
 		zerolog.Ctx(ctx).Err(err).
 			Msg("Failed to send management room message")
+		return ""
 	}
+	return resp.EventID
 }
@@ -11,6 +11,9 @@ import (
 	"maunium.net/go/mautrix/id"
 
 	"go.mau.fi/meowlnir/config"
+	"go.mau.fi/meowlnir/database"
+	"go.mau.fi/meowlnir/policyeval"
+	"go.mau.fi/meowlnir/policylist"
 )
 
 func (m *Meowlnir) AddEventHandlers() {
@@ -47,6 +50,7 @@ func (m *Meowlnir) AddEventHandlers() {
 	m.EventProcessor.On(event.StateMember, m.HandleMember)
 	m.EventProcessor.On(event.EventMessage, m.HandleMessage)
 	m.EventProcessor.On(event.EventSticker, m.HandleMessage)
+	m.EventProcessor.On(event.EventReaction, m.HandleReaction)
 	m.EventProcessor.On(event.EventEncrypted, m.HandleEncrypted)
 }
 
@@ -84,11 +88,38 @@ func (m *Meowlnir) HandleOTKCounts(ctx context.Context, evt *mautrix.OTKCount) {
 
 func (m *Meowlnir) UpdatePolicyList(ctx context.Context, evt *event.Event) {
 	added, removed := m.PolicyStore.Update(evt)
+	if m.PolicyStore.Contains(evt.RoomID) {
+		m.cachePolicyEvent(ctx, evt, removed)
+	}
 	for _, eval := range m.EvaluatorByManagementRoom {
 		eval.HandlePolicyListChange(ctx, evt.RoomID, added, removed)
 	}
 }
 
+func (m *Meowlnir) cachePolicyEvent(ctx context.Context, evt *event.Event, removed *policylist.Policy) {
+	var err error
+	if evt.Type != event.EventRedaction {
+		err = m.DB.PolicyEvent.Put(ctx, database.PolicyEventFromEvent(evt))
+	} else if removed != nil {
+		// The redacted policy event is still in the room state, but with empty content
+		err = m.DB.PolicyEvent.Put(ctx, &database.PolicyEvent{
+			RoomID:    removed.RoomID,
+			Type:      removed.Type,
+			StateKey:  removed.StateKey,
+			EventID:   removed.ID,
+			Sender:    removed.Sender,
+			Timestamp: removed.Timestamp,
+			Content:   json.RawMessage("{}"),
+		})
+	}
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).
+			Stringer("room_id", evt.RoomID).
+			Stringer("event_id", evt.ID).
+			Msg("Failed to cache policy event")
+	}
+}
+
 func (m *Meowlnir) HandleConfigChange(ctx context.Context, evt *event.Event) {
 	evtx, _ := json.MarshalIndent(evt, " ", "\t")
 	fmt.Println("HandleConfigChange.evtx:", string(evtx))
@@ -114,7 +145,20 @@ func (m *Meowlnir) HandleMember(ctx context.Context, evt *event.Event) {
 	bot, botOK := m.Bots[id.UserID(evt.GetStateKey())]
 	managementRoom, _ := m.EvaluatorByManagementRoom[evt.RoomID]
 	roomProtector, protectedOK := m.EvaluatorByProtectedRoom[evt.RoomID]
+	var botEvaluators []*policyeval.PolicyEvaluator
+	if botOK && content.Membership == event.MembershipInvite {
+		for _, eval := range m.EvaluatorByManagementRoom {
+			if eval.Bot == bot {
+				botEvaluators = append(botEvaluators, eval)
+			}
+		}
+	}
 	m.MapLock.RUnlock()
+	for _, eval := range botEvaluators {
+		if eval.HandleBotInvite(ctx, evt) {
+			return
+		}
+	}
 	if botOK && content.Membership == event.MembershipInvite {
 		_, err := bot.Client.JoinRoomByID(ctx, evt.RoomID)
 		if err != nil {
@@ -163,6 +207,15 @@ func (m *Meowlnir) HandleEncrypted(ctx context.Context, evt *event.Event) {
 func (m *Meowlnir) HandleMessage(ctx context.Context, evt *event.Event) {
 	evtx, _ := json.MarshalIndent(evt, " ", "\t")
 	fmt.Println("HandleMessage.evtx:", string(evtx))
+	if evt.Content.Parsed == nil {
+		// Events passed through CustomPostDecrypt should already be parsed, but make sure
+		_ = evt.Content.ParseRaw(evt.Type)
+	}
+	if evt.Type == event.EventReaction {
+		// Decrypted reactions are also passed here by CustomPostDecrypt
+		m.HandleReaction(ctx, evt)
+		return
+	}
 	content, ok := evt.Content.Parsed.(*event.MessageEventContent)
 	if !ok {
 		return
@@ -183,3 +236,14 @@ func (m *Meowlnir) HandleMessage(ctx context.Context, evt *event.Event) {
 		roomProtector.HandleMessage(ctx, evt)
 	}
 }
+
+func (m *Meowlnir) HandleReaction(ctx context.Context, evt *event.Event) {
+	m.MapLock.RLock()
+	_, isBot := m.Bots[evt.Sender]
+	managementRoom, isManagement := m.EvaluatorByManagementRoom[evt.RoomID]
+	m.MapLock.RUnlock()
+	if isBot || !isManagement {
+		return
+	}
+	managementRoom.HandleReaction(ctx, evt)
+}
@@ -12,7 +12,9 @@ import (
 func (m *Meowlnir) AddHTTPEndpoints() {
 	clientRouter := http.NewServeMux()
 	clientRouter.HandleFunc("POST /v3/rooms/{roomID}/report/{eventID}", m.PostReport)
-	clientRouter.HandleFunc("POST /v3/rooms/{roomID}", m.PostReport)
+	clientRouter.HandleFunc("POST /v3/rooms/{roomID}/report", m.PostRoomReport)
+	clientRouter.HandleFunc("POST /unstable/org.matrix.msc4151/rooms/{roomID}/report", m.PostRoomReport)
+	clientRouter.HandleFunc("POST /v3/users/{userID}/report", m.PostUserReport)
 	m.AS.Router.PathPrefix("/_matrix/client").Handler(applyMiddleware(
 		http.StripPrefix("/_matrix/client", clientRouter),
 		hlog.NewHandler(m.Log.With().Str("component", "reporting api").Logger()),
@@ -26,6 +28,7 @@ func (m *Meowlnir) AddHTTPEndpoints() {
 	managementRouter.HandleFunc("PUT /v1/bot/{username}", m.PutBot)
 	managementRouter.HandleFunc("POST /v1/bot/{username}/verify", m.PostVerifyBot)
 	managementRouter.HandleFunc("PUT /v1/management_room/{roomID}", m.PutManagementRoom)
+	managementRouter.HandleFunc("GET /v1/management_room/{roomID}/reports", m.GetReports)
 
 	m.AS.Router.PathPrefix("/_matrix/meowlnir").Handler(applyMiddleware(
 		http.StripPrefix("/_matrix/meowlnir", managementRouter),
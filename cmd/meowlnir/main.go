@@ -56,6 +56,11 @@ type Meowlnir struct {
 	Bots                      map[id.UserID]*bot.Bot
 	EvaluatorByProtectedRoom  map[id.RoomID]*policyeval.PolicyEvaluator
 	EvaluatorByManagementRoom map[id.RoomID]*policyeval.PolicyEvaluator
+
+	// evaluatorsByBot contains the same evaluators as EvaluatorByManagementRoom grouped by bot.
+	// It has a separate lock, as evaluators use it while MapLock may already be held.
+	evaluatorsByBot     map[id.UserID][]*policyeval.PolicyEvaluator
+	evaluatorsByBotLock sync.RWMutex
 }
 
 func (m *Meowlnir) Init(configPath string, noSaveConfig bool) {
@@ -83,6 +88,10 @@ func (m *Meowlnir) Init(configPath string, noSaveConfig bool) {
 		os.Exit(11)
 	}
 	exzerolog.SetupDefaults(m.Log)
+	if threshold := m.Config.Meowlnir.ReportThreshold; threshold.Reporters > 0 && threshold.Window <= 0 {
+		m.Log.WithLevel(zerolog.FatalLevel).Msg("Report threshold window must be positive when the threshold is enabled")
+		os.Exit(10)
+	}
 
 	m.Log.Info().
 		Str("version", VersionWithCommit).
@@ -165,6 +174,44 @@ func (m *Meowlnir) claimProtectedRoom(roomID id.RoomID, eval *policyeval.PolicyE
 	return eval
 }
 
+// updateEvaluatorsByBot rebuilds evaluatorsByBot. MapLock must be held when calling this.
+func (m *Meowlnir) updateEvaluatorsByBot() {
+	evaluatorsByBot := make(map[id.UserID][]*policyeval.PolicyEvaluator)
+	for _, eval := range m.EvaluatorByManagementRoom {
+		evaluatorsByBot[eval.Bot.UserID] = append(evaluatorsByBot[eval.Bot.UserID], eval)
+	}
+	m.evaluatorsByBotLock.Lock()
+	m.evaluatorsByBot = evaluatorsByBot
+	m.evaluatorsByBotLock.Unlock()
+}
+
+// isRoomUsedByBot checks if any management room of the given bot uses the room, which means the bot must not leave it.
+func (m *Meowlnir) isRoomUsedByBot(userID id.UserID, roomID id.RoomID) bool {
+	m.evaluatorsByBotLock.RLock()
+	evaluators := m.evaluatorsByBot[userID]
+	m.evaluatorsByBotLock.RUnlock()
+	for _, eval := range evaluators {
+		if eval.IsUsingRoom(roomID) {
+			return true
+		}
+	}
+	return false
+}
+
+// isPolicyListWatched checks if any management room watches the given policy list.
+func (m *Meowlnir) isPolicyListWatched(roomID id.RoomID) bool {
+	m.evaluatorsByBotLock.RLock()
+	defer m.evaluatorsByBotLock.RUnlock()
+	for _, evaluators := range m.evaluatorsByBot {
+		for _, eval := range evaluators {
+			if eval.GetWatchedListMeta(roomID) != nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func (m *Meowlnir) initBot(ctx context.Context, db *database.Bot) *bot.Bot {
 	intent := m.AS.Intent(id.NewUserID(db.Username, m.AS.HomeserverDomain))
 	wrapped := bot.NewBot(
@@ -182,9 +229,11 @@ func (m *Meowlnir) initBot(ctx context.Context, db *database.Bot) *bot.Bot {
 	}
 	for _, roomID := range managementRooms {
 		m.EvaluatorByManagementRoom[roomID] = policyeval.NewPolicyEvaluator(
-			wrapped, m.PolicyStore, roomID, m.DB, m.SynapseDB, m.claimProtectedRoom, m.Config.Meowlnir.DryRun,
+			wrapped, m.PolicyStore, roomID, m.DB, m.SynapseDB, m.claimProtectedRoom, m.UpdatePolicyList, m.isRoomUsedByBot, m.isPolicyListWatched,
+			m.Config.Meowlnir.DryRun, m.Config.Meowlnir.ReportReactions, m.Config.Meowlnir.ReportThreshold,
 		)
 	}
+	m.updateEvaluatorsByBot()
 	return wrapped
 }
 
@@ -204,9 +253,11 @@ func (m *Meowlnir) loadManagementRoom(ctx context.Context, roomID id.RoomID, bot
 		}
 	}
 	eval = policyeval.NewPolicyEvaluator(
-		bot, m.PolicyStore, roomID, m.DB, m.SynapseDB, m.claimProtectedRoom, m.Config.Meowlnir.DryRun,
+		bot, m.PolicyStore, roomID, m.DB, m.SynapseDB, m.claimProtectedRoom, m.UpdatePolicyList, m.isRoomUsedByBot, m.isPolicyListWatched,
+		m.Config.Meowlnir.DryRun, m.Config.Meowlnir.ReportReactions, m.Config.Meowlnir.ReportThreshold,
 	)
 	m.EvaluatorByManagementRoom[roomID] = eval
+	m.updateEvaluatorsByBot()
 	eval.Load(ctx)
 	return true
 }
@@ -244,6 +295,7 @@ func (m *Meowlnir) Run(ctx context.Context) {
 
 	m.EventProcessor.Start(ctx)
 	go m.AS.Start()
+	go m.PolicyExpiryLoop(ctx)
 
 	for _, room := range m.EvaluatorByManagementRoom {
 		room.Load(ctx)
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// PolicyExpiryLoop removes policies from the store when they expire and notifies all evaluators about the removal.
+func (m *Meowlnir) PolicyExpiryLoop(ctx context.Context) {
+	log := zerolog.Ctx(ctx).With().Str("action", "policy expiry loop").Logger()
+	ctx = log.WithContext(ctx)
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		next := m.PolicyStore.NextExpiry()
+		if !next.IsZero() {
+			timer.Reset(time.Until(next))
+		} else {
+			timer.Stop()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-m.PolicyStore.ExpiryUpdated():
+			timer.Stop()
+		case <-timer.C:
+			expired := m.PolicyStore.RemoveExpired(time.Now())
+			if len(expired) == 0 {
+				continue
+			}
+			log.Debug().Int("expired_count", len(expired)).Msg("Removing expired policies")
+			m.MapLock.RLock()
+			evaluators := slices.Collect(maps.Values(m.EvaluatorByManagementRoom))
+			m.MapLock.RUnlock()
+			for _, policy := range expired {
+				for _, eval := range evaluators {
+					eval.HandlePolicyListChange(ctx, policy.RoomID, nil, policy)
+				}
+			}
+		}
+	}
+}
@@ -7,10 +7,13 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/hlog"
 	"go.mau.fi/util/exhttp"
 	"maunium.net/go/mautrix"
 	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/meowlnir/policyeval"
 )
 
 type contextKey int
@@ -45,32 +48,26 @@ func (m *Meowlnir) checkMatrixAuth(ctx context.Context, token string) (*mautrix.
 	return client.Whoami(ctx)
 }
 
-func (m *Meowlnir) PostReport(w http.ResponseWriter, r *http.Request) {
+// prepareReport parses a report request and finds the management room that should handle it.
+// If an error occurs, it's written to the response and nil is returned.
+func (m *Meowlnir) prepareReport(w http.ResponseWriter, r *http.Request) (*policyeval.PolicyEvaluator, *mautrix.ReqReport) {
 	var req mautrix.ReqReport
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
 		mautrix.MBadJSON.WithMessage("Invalid JSON").Write(w)
-		return
+		return nil, nil
 	}
 	m.MapLock.RLock()
 	mgmtRoom, ok := m.EvaluatorByManagementRoom[m.Config.Meowlnir.ReportRoom]
 	m.MapLock.RUnlock()
 	if !ok {
 		mautrix.MUnrecognized.WithMessage("Reporting is not configured correctly").Write(w)
-		return
+		return nil, nil
 	}
+	return mgmtRoom, &req
+}
 
-	roomID := id.RoomID(r.PathValue("roomID"))
-	eventID := id.EventID(r.PathValue("eventID"))
-	userID := r.Context().Value(contextKeyClientUserID).(id.UserID)
-	log := hlog.FromRequest(r).With().
-		Stringer("report_room_id", roomID).
-		Stringer("report_event_id", eventID).
-		Stringer("reporter_sender", userID).
-		Str("action", "handle report").
-		Logger()
-	ctx := context.WithoutCancel(log.WithContext(r.Context()))
-	err = mgmtRoom.HandleReport(ctx, userID, roomID, eventID, req.Reason)
+func writeReportResponse(w http.ResponseWriter, log *zerolog.Logger, err error) {
 	if err != nil {
 		log.Err(err).Msg("Failed to handle report")
 		var respErr mautrix.RespError
@@ -83,3 +80,57 @@ func (m *Meowlnir) PostReport(w http.ResponseWriter, r *http.Request) {
 		exhttp.WriteEmptyJSONResponse(w, http.StatusOK)
 	}
 }
+
+func (m *Meowlnir) PostReport(w http.ResponseWriter, r *http.Request) {
+	mgmtRoom, req := m.prepareReport(w, r)
+	if mgmtRoom == nil {
+		return
+	}
+	roomID := id.RoomID(r.PathValue("roomID"))
+	eventID := id.EventID(r.PathValue("eventID"))
+	userID := r.Context().Value(contextKeyClientUserID).(id.UserID)
+	log := hlog.FromRequest(r).With().
+		Stringer("report_room_id", roomID).
+		Stringer("report_event_id", eventID).
+		Stringer("reporter_sender", userID).
+		Str("action", "handle report").
+		Logger()
+	ctx := context.WithoutCancel(log.WithContext(r.Context()))
+	writeReportResponse(w, &log, mgmtRoom.HandleReport(ctx, userID, roomID, eventID, req.Reason))
+}
+
+func (m *Meowlnir) PostRoomReport(w http.ResponseWriter, r *http.Request) {
+	mgmtRoom, req := m.prepareReport(w, r)
+	if mgmtRoom == nil {
+		return
+	}
+	roomID := id.RoomID(r.PathValue("roomID"))
+	userID := r.Context().Value(contextKeyClientUserID).(id.UserID)
+	log := hlog.FromRequest(r).With().
+		Stringer("report_room_id", roomID).
+		Stringer("reporter_sender", userID).
+		Str("action", "handle room report").
+		Logger()
+	ctx := context.WithoutCancel(log.WithContext(r.Context()))
+	writeReportResponse(w, &log, mgmtRoom.HandleRoomReport(ctx, userID, roomID, req.Reason))
+}
+
+func (m *Meowlnir) PostUserReport(w http.ResponseWriter, r *http.Request) {
+	targetUserID := id.UserID(r.PathValue("userID"))
+	if _, _, err := targetUserID.Parse(); err != nil {
+		mautrix.MInvalidParam.WithMessage("Invalid user ID").Write(w)
+		return
+	}
+	mgmtRoom, req := m.prepareReport(w, r)
+	if mgmtRoom == nil {
+		return
+	}
+	userID := r.Context().Value(contextKeyClientUserID).(id.UserID)
+	log := hlog.FromRequest(r).With().
+		Stringer("report_user_id", targetUserID).
+		Stringer("reporter_sender", userID).
+		Str("action", "handle user report").
+		Logger()
+	ctx := context.WithoutCancel(log.WithContext(r.Context()))
+	writeReportResponse(w, &log, mgmtRoom.HandleUserReport(ctx, userID, targetUserID, req.Reason))
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/rs/zerolog/hlog"
+	"go.mau.fi/util/exhttp"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/meowlnir/database"
+)
+
+const (
+	defaultReportLimit = 100
+	maxReportLimit     = 1000
+)
+
+type RespGetReports struct {
+	Cases []*database.ReportCase `json:"cases"`
+}
+
+func (m *Meowlnir) GetReports(w http.ResponseWriter, r *http.Request) {
+	roomID := id.RoomID(r.PathValue("roomID"))
+	m.MapLock.RLock()
+	_, ok := m.EvaluatorByManagementRoom[roomID]
+	m.MapLock.RUnlock()
+	if !ok {
+		mautrix.MNotFound.WithMessage("Management room not found").Write(w)
+		return
+	}
+	query := r.URL.Query()
+	filter := &database.ReportFilter{
+		Status:     database.ReportStatus(query.Get("status")),
+		TargetUser: id.UserID(query.Get("target_user")),
+		Reporter:   id.UserID(query.Get("reporter")),
+		RoomID:     id.RoomID(query.Get("room_id")),
+		Limit:      defaultReportLimit,
+	}
+	switch filter.Status {
+	case "", database.ReportStatusOpen, database.ReportStatusActioned, database.ReportStatusDismissed:
+	default:
+		mautrix.MInvalidParam.WithMessage("Invalid status filter").Write(w)
+		return
+	}
+	if limitStr := query.Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 || limit > maxReportLimit {
+			mautrix.MInvalidParam.WithMessage("Invalid limit").Write(w)
+			return
+		}
+		filter.Limit = limit
+	}
+	reports, err := m.DB.Report.GetFiltered(r.Context(), roomID, filter)
+	if err != nil {
+		hlog.FromRequest(r).Err(err).Msg("Failed to get reports")
+		mautrix.MUnknown.WithMessage("Failed to get reports").Write(w)
+		return
+	}
+	exhttp.WriteJSONResponse(w, http.StatusOK, &RespGetReports{Cases: database.GroupReports(reports)})
+}
@@ -2,6 +2,7 @@ package config
 
 import (
 	_ "embed"
+	"time"
 
 	"go.mau.fi/util/dbutil"
 	"go.mau.fi/zeroconfig"
@@ -16,6 +17,22 @@ type HomeserverConfig struct {
 	Domain  string `yaml:"domain"`
 }
 
+// ReportReactionsConfig contains the reactions that can be used to act on report notices.
+type ReportReactionsConfig struct {
+	Ban       string `yaml:"ban"`
+	BanList   string `yaml:"ban_list"`
+	BanReason string `yaml:"ban_reason"`
+	Redact    string `yaml:"redact"`
+	Dismiss   string `yaml:"dismiss"`
+}
+
+// ReportThresholdConfig configures automatic actions against users who are reported by many different users.
+type ReportThresholdConfig struct {
+	Reporters int           `yaml:"reporters"`
+	Window    time.Duration `yaml:"window"`
+	TempBan   time.Duration `yaml:"temp_ban"`
+}
+
 type MeowlnirConfig struct {
 	ID      string `yaml:"id"`
 	ASToken string `yaml:"as_token"`
@@ -29,7 +46,9 @@ type MeowlnirConfig struct {
 	ManagementSecret string `yaml:"management_secret"`
 	DryRun           bool   `yaml:"dry_run"`
 
-	ReportRoom id.RoomID `yaml:"report_room"`
+	ReportRoom      id.RoomID             `yaml:"report_room"`
+	ReportReactions ReportReactionsConfig `yaml:"report_reactions"`
+	ReportThreshold ReportThresholdConfig `yaml:"report_threshold"`
 
 	HackyRuleFilter []string `yaml:"hacky_rule_filter"`
 }
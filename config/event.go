@@ -1,8 +1,12 @@
 package config
 
 import (
+	"fmt"
 	"reflect"
+	"regexp"
+	"strings"
 
+	"go.mau.fi/util/glob"
 	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/id"
 )
@@ -12,12 +16,94 @@ var (
 	StateProtectedRooms = event.Type{Type: "fi.mau.meowlnir.protected_rooms", Class: event.StateEventType}
 )
 
+// RoomBanAction specifies what should be done on the homeserver when a policy list bans a room.
+type RoomBanAction string
+
+const (
+	// RoomBanActionBlock blocks the room using the Synapse admin API, which prevents local users from joining it.
+	RoomBanActionBlock RoomBanAction = "block"
+	// RoomBanActionPurge blocks the room, kicks all local users and purges it from the database.
+	RoomBanActionPurge RoomBanAction = "purge"
+	// RoomBanActionNone doesn't do anything on the homeserver, the bot will only refuse invites to the room.
+	// This is the default action if no action is specified.
+	RoomBanActionNone RoomBanAction = "none"
+)
+
+// RedactAction specifies which events of a banned user should be redacted.
+type RedactAction string
+
+const (
+	// RedactActionAll redacts all events the user has sent in protected rooms.
+	RedactActionAll RedactAction = "all"
+	// RedactActionRecent only redacts events sent within the last day.
+	RedactActionRecent RedactAction = "recent"
+	// RedactActionNone doesn't redact anything.
+	RedactActionNone RedactAction = "none"
+)
+
+// RedactRule maps ban reasons to a redaction action.
+type RedactRule struct {
+	// Reason is a glob pattern (or a regex if Regex is true) that is matched against the reason of the ban policy.
+	// Glob patterns are case-insensitive.
+	Reason string       `json:"reason"`
+	Regex  bool         `json:"regex,omitempty"`
+	Action RedactAction `json:"action"`
+
+	compiledGlob  glob.Glob
+	compiledRegex *regexp.Regexp
+}
+
+// Compile prepares the rule for matching. It must be called before Match.
+func (rr *RedactRule) Compile() (err error) {
+	switch rr.Action {
+	case RedactActionAll, RedactActionRecent, RedactActionNone:
+	default:
+		return fmt.Errorf("unknown redact action %q", rr.Action)
+	}
+	if rr.Regex {
+		rr.compiledRegex, err = regexp.Compile(rr.Reason)
+	} else {
+		rr.compiledGlob = glob.Compile(strings.ToLower(rr.Reason))
+	}
+	return
+}
+
+// Match checks if the given ban reason matches this rule.
+func (rr *RedactRule) Match(reason string) bool {
+	if rr.compiledRegex != nil {
+		return rr.compiledRegex.MatchString(reason)
+	} else if rr.compiledGlob != nil {
+		return rr.compiledGlob.Match(strings.ToLower(reason))
+	}
+	return false
+}
+
 type WatchedPolicyList struct {
-	RoomID    id.RoomID `json:"room_id"`
-	Name      string    `json:"name"`
-	Shortcode string    `json:"shortcode"`
-	DontApply bool      `json:"dont_apply"`
-	AutoUnban bool      `json:"auto_unban"`
+	RoomID        id.RoomID     `json:"room_id"`
+	Name          string        `json:"name"`
+	Shortcode     string        `json:"shortcode"`
+	DontApply     bool          `json:"dont_apply"`
+	AutoUnban     bool          `json:"auto_unban"`
+	RoomBanAction RoomBanAction `json:"room_ban_action,omitempty"`
+	RedactOnBan   []*RedactRule `json:"redact_on_ban,omitempty"`
+}
+
+// RedactActionFor finds the redaction action for a ban with the given reason. The first matching rule is used.
+//
+// If the list doesn't have any redaction rules, events are only redacted for bans with the reason "spam".
+func (wpl *WatchedPolicyList) RedactActionFor(reason string) RedactAction {
+	if wpl.RedactOnBan == nil {
+		if reason == "spam" {
+			return RedactActionAll
+		}
+		return RedactActionNone
+	}
+	for _, rule := range wpl.RedactOnBan {
+		if rule.Match(reason) {
+			return rule.Action
+		}
+	}
+	return RedactActionNone
 }
 
 type WatchedListsEventContent struct {
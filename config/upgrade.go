@@ -33,6 +33,14 @@ func upgradeConfig(helper up.Helper) {
 	generateOrCopy(helper, "meowlnir", "management_secret")
 	helper.Copy(up.Bool, "meowlnir", "dry_run")
 	helper.Copy(up.Str|up.Null, "meowlnir", "report_room")
+	helper.Copy(up.Str, "meowlnir", "report_reactions", "ban")
+	helper.Copy(up.Str|up.Null, "meowlnir", "report_reactions", "ban_list")
+	helper.Copy(up.Str, "meowlnir", "report_reactions", "ban_reason")
+	helper.Copy(up.Str, "meowlnir", "report_reactions", "redact")
+	helper.Copy(up.Str, "meowlnir", "report_reactions", "dismiss")
+	helper.Copy(up.Int, "meowlnir", "report_threshold", "reporters")
+	helper.Copy(up.Str|up.Int, "meowlnir", "report_threshold", "window")
+	helper.Copy(up.Str|up.Int, "meowlnir", "report_threshold", "temp_ban")
 	helper.Copy(up.List, "meowlnir", "hacky_rule_filter")
 
 	helper.Copy(up.Str, "database", "type")
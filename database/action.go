@@ -11,18 +11,22 @@ import (
 
 const (
 	getTakenActionBaseQuery = `
-		SELECT target_user, in_room_id, action_type, policy_list, rule_entity, action, taken_at
+		SELECT target_user, in_room_id, action_type, policy_list, rule_entity, rule_type, action, taken_at
 		FROM taken_action
 	`
 	getTakenActionsByPolicyListQuery = getTakenActionBaseQuery + `WHERE policy_list=$1`
 	getTakenActionsByRuleEntityQuery = getTakenActionBaseQuery + `WHERE policy_list=$1 AND rule_entity=$2`
 	getTakenActionByTargetUserQuery  = getTakenActionBaseQuery + `WHERE target_user=$1 AND action_type=$2`
+	getTakenActionsByTypeQuery       = getTakenActionBaseQuery + `WHERE action_type=$1`
+	getAllTakenActionsByTargetQuery  = getTakenActionBaseQuery + `WHERE target_user=$1 ORDER BY taken_at DESC`
 	insertTakenActionQuery           = `
-		INSERT INTO taken_action (target_user, in_room_id, action_type, policy_list, rule_entity, action, taken_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		INSERT INTO taken_action (target_user, in_room_id, action_type, policy_list, rule_entity, rule_type, action, taken_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
 		ON CONFLICT (target_user, in_room_id, action_type) DO UPDATE
-			SET policy_list=excluded.policy_list, rule_entity=excluded.rule_entity, action=excluded.action, taken_at=excluded.taken_at
+			SET policy_list=excluded.policy_list, rule_entity=excluded.rule_entity, rule_type=excluded.rule_type,
+			    action=excluded.action, taken_at=excluded.taken_at
 	`
+	deleteTakenActionQuery = `DELETE FROM taken_action WHERE target_user=$1 AND in_room_id=$2 AND action_type=$3`
 )
 
 type TakenActionQuery struct {
@@ -33,6 +37,10 @@ func (taq *TakenActionQuery) Put(ctx context.Context, ta *TakenAction) error {
 	return taq.Exec(ctx, insertTakenActionQuery, ta.sqlVariables()...)
 }
 
+func (taq *TakenActionQuery) Delete(ctx context.Context, ta *TakenAction) error {
+	return taq.Exec(ctx, deleteTakenActionQuery, ta.TargetUser, ta.InRoomID, ta.ActionType)
+}
+
 func (taq *TakenActionQuery) GetAllByPolicyList(ctx context.Context, policyList id.RoomID) ([]*TakenAction, error) {
 	return taq.QueryMany(ctx, getTakenActionsByPolicyListQuery, policyList)
 }
@@ -45,29 +53,45 @@ func (taq *TakenActionQuery) GetAllByTargetUser(ctx context.Context, userID id.U
 	return taq.QueryMany(ctx, getTakenActionByTargetUserQuery, userID, actionType)
 }
 
+// GetAllByType returns all actions of the given type.
+func (taq *TakenActionQuery) GetAllByType(ctx context.Context, actionType TakenActionType) ([]*TakenAction, error) {
+	return taq.QueryMany(ctx, getTakenActionsByTypeQuery, actionType)
+}
+
+// GetAllForUser returns all actions of any type taken against the given user, newest first.
+func (taq *TakenActionQuery) GetAllForUser(ctx context.Context, userID id.UserID) ([]*TakenAction, error) {
+	return taq.QueryMany(ctx, getAllTakenActionsByTargetQuery, userID)
+}
+
 type TakenActionType string
 
 const (
 	TakenActionTypeBanOrUnban TakenActionType = "ban_or_unban"
+	// TakenActionTypeRoomBlock is used when a room is blocked on the homeserver because of a room ban policy.
+	// The target user is empty and in_room_id is the blocked room.
+	TakenActionTypeRoomBlock TakenActionType = "room_block"
 )
 
 type TakenAction struct {
-	TargetUser id.UserID
-	InRoomID   id.RoomID
-	ActionType TakenActionType
-	PolicyList id.RoomID
-	RuleEntity string
-	Action     event.PolicyRecommendation
-	TakenAt    time.Time
+	TargetUser id.UserID       `json:"target_user"`
+	InRoomID   id.RoomID       `json:"in_room_id"`
+	ActionType TakenActionType `json:"action_type"`
+	PolicyList id.RoomID       `json:"policy_list"`
+	RuleEntity string          `json:"rule_entity"`
+	// RuleType is the entity type of the rule that caused the action, e.g. `server` if a user was banned
+	// because their homeserver is banned.
+	RuleType string                     `json:"rule_type"`
+	Action   event.PolicyRecommendation `json:"action"`
+	TakenAt  time.Time                  `json:"taken_at"`
 }
 
 func (t *TakenAction) sqlVariables() []any {
-	return []any{t.TargetUser, t.InRoomID, t.ActionType, t.PolicyList, t.RuleEntity, t.Action, t.TakenAt.UnixMilli()}
+	return []any{t.TargetUser, t.InRoomID, t.ActionType, t.PolicyList, t.RuleEntity, t.RuleType, t.Action, t.TakenAt.UnixMilli()}
 }
 
 func (t *TakenAction) Scan(row dbutil.Scannable) (*TakenAction, error) {
 	var takenAt int64
-	err := row.Scan(&t.TargetUser, &t.InRoomID, &t.ActionType, &t.PolicyList, &t.RuleEntity, &t.Action, &takenAt)
+	err := row.Scan(&t.TargetUser, &t.InRoomID, &t.ActionType, &t.PolicyList, &t.RuleEntity, &t.RuleType, &t.Action, &takenAt)
 	if err != nil {
 		return nil, err
 	}
@@ -11,6 +11,9 @@ type Database struct {
 	TakenAction    *TakenActionQuery
 	Bot            *BotQuery
 	ManagementRoom *ManagementRoomQuery
+	PolicyEvent    *PolicyEventQuery
+	QueuedAction   *QueuedActionQuery
+	Report         *ReportQuery
 }
 
 func New(db *dbutil.Database) *Database {
@@ -30,5 +33,20 @@ func New(db *dbutil.Database) *Database {
 		ManagementRoom: &ManagementRoomQuery{
 			Database: db,
 		},
+		PolicyEvent: &PolicyEventQuery{
+			QueryHelper: dbutil.MakeQueryHelper(db, func(qh *dbutil.QueryHelper[*PolicyEvent]) *PolicyEvent {
+				return &PolicyEvent{}
+			}),
+		},
+		QueuedAction: &QueuedActionQuery{
+			QueryHelper: dbutil.MakeQueryHelper(db, func(qh *dbutil.QueryHelper[*QueuedAction]) *QueuedAction {
+				return &QueuedAction{}
+			}),
+		},
+		Report: &ReportQuery{
+			QueryHelper: dbutil.MakeQueryHelper(db, func(qh *dbutil.QueryHelper[*Report]) *Report {
+				return &Report{}
+			}),
+		},
 	}
 }
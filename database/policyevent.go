@@ -0,0 +1,154 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"go.mau.fi/util/dbutil"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	getPolicyEventsByRoomQuery = `
+		SELECT room_id, event_type, state_key, event_id, sender, timestamp, content
+		FROM policy_event
+		WHERE room_id=$1
+	`
+	putPolicyEventQuery = `
+		INSERT INTO policy_event (room_id, event_type, state_key, event_id, sender, timestamp, content)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (room_id, event_type, state_key) DO UPDATE
+			SET event_id=excluded.event_id, sender=excluded.sender, timestamp=excluded.timestamp, content=excluded.content
+	`
+	deletePolicyEventQuery = `
+		DELETE FROM policy_event WHERE room_id=$1 AND event_type=$2 AND state_key=$3
+	`
+	deletePolicyEventsByRoomQuery = `DELETE FROM policy_event WHERE room_id=$1`
+	isPolicyListCachedQuery       = `SELECT EXISTS(SELECT 1 FROM policy_list_cache WHERE room_id=$1)`
+	markPolicyListCachedQuery     = `
+		INSERT INTO policy_list_cache (room_id, cached_at) VALUES ($1, $2)
+		ON CONFLICT (room_id) DO UPDATE SET cached_at=excluded.cached_at
+	`
+	deletePolicyListCacheQuery = `DELETE FROM policy_list_cache WHERE room_id=$1`
+)
+
+type PolicyEventQuery struct {
+	*dbutil.QueryHelper[*PolicyEvent]
+}
+
+func (peq *PolicyEventQuery) GetAllByRoom(ctx context.Context, roomID id.RoomID) ([]*PolicyEvent, error) {
+	return peq.QueryMany(ctx, getPolicyEventsByRoomQuery, roomID)
+}
+
+func (peq *PolicyEventQuery) Put(ctx context.Context, evt *PolicyEvent) error {
+	return peq.Exec(ctx, putPolicyEventQuery, evt.sqlVariables()...)
+}
+
+// PutMany inserts or replaces all the given events in a single transaction.
+func (peq *PolicyEventQuery) PutMany(ctx context.Context, evts []*PolicyEvent) error {
+	return peq.GetDB().DoTxn(ctx, nil, func(ctx context.Context) error {
+		for _, evt := range evts {
+			err := peq.Put(ctx, evt)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// IsRoomCached returns true if the full state of the given policy list has been saved with PutRoomState.
+func (peq *PolicyEventQuery) IsRoomCached(ctx context.Context, roomID id.RoomID) (cached bool, err error) {
+	err = peq.GetDB().QueryRow(ctx, isPolicyListCachedQuery, roomID).Scan(&cached)
+	return
+}
+
+// PutRoomState replaces the cached events of the given policy list and marks the list as fully cached.
+func (peq *PolicyEventQuery) PutRoomState(ctx context.Context, roomID id.RoomID, evts []*PolicyEvent) error {
+	return peq.GetDB().DoTxn(ctx, nil, func(ctx context.Context) error {
+		err := peq.Exec(ctx, deletePolicyEventsByRoomQuery, roomID)
+		if err != nil {
+			return err
+		}
+		err = peq.PutMany(ctx, evts)
+		if err != nil {
+			return err
+		}
+		return peq.Exec(ctx, markPolicyListCachedQuery, roomID, time.Now().UnixMilli())
+	})
+}
+
+// DeleteRoom deletes all cached events of the given policy list and the marker saying that it's cached.
+func (peq *PolicyEventQuery) DeleteRoom(ctx context.Context, roomID id.RoomID) error {
+	return peq.GetDB().DoTxn(ctx, nil, func(ctx context.Context) error {
+		err := peq.Exec(ctx, deletePolicyListCacheQuery, roomID)
+		if err != nil {
+			return err
+		}
+		return peq.Exec(ctx, deletePolicyEventsByRoomQuery, roomID)
+	})
+}
+
+func (peq *PolicyEventQuery) Delete(ctx context.Context, roomID id.RoomID, eventType event.Type, stateKey string) error {
+	return peq.Exec(ctx, deletePolicyEventQuery, roomID, eventType.Type, stateKey)
+}
+
+// PolicyEvent is a cached moderation policy state event.
+type PolicyEvent struct {
+	RoomID    id.RoomID
+	Type      event.Type
+	StateKey  string
+	EventID   id.EventID
+	Sender    id.UserID
+	Timestamp int64
+	Content   json.RawMessage
+}
+
+// PolicyEventFromEvent converts a state event into a database row.
+func PolicyEventFromEvent(evt *event.Event) *PolicyEvent {
+	content := evt.Content.VeryRaw
+	if content == nil {
+		content, _ = json.Marshal(evt.Content.Raw)
+	}
+	return &PolicyEvent{
+		RoomID:    evt.RoomID,
+		Type:      evt.Type,
+		StateKey:  evt.GetStateKey(),
+		EventID:   evt.ID,
+		Sender:    evt.Sender,
+		Timestamp: evt.Timestamp,
+		Content:   content,
+	}
+}
+
+// ToEvent converts the database row back into a state event with parsed content.
+func (pe *PolicyEvent) ToEvent() *event.Event {
+	evt := &event.Event{
+		RoomID:    pe.RoomID,
+		Type:      pe.Type,
+		StateKey:  &pe.StateKey,
+		ID:        pe.EventID,
+		Sender:    pe.Sender,
+		Timestamp: pe.Timestamp,
+		Content:   event.Content{VeryRaw: pe.Content},
+	}
+	_ = evt.Content.ParseRaw(evt.Type)
+	return evt
+}
+
+func (pe *PolicyEvent) sqlVariables() []any {
+	return []any{pe.RoomID, pe.Type.Type, pe.StateKey, pe.EventID, pe.Sender, pe.Timestamp, string(pe.Content)}
+}
+
+func (pe *PolicyEvent) Scan(row dbutil.Scannable) (*PolicyEvent, error) {
+	var content string
+	err := row.Scan(&pe.RoomID, &pe.Type.Type, &pe.StateKey, &pe.EventID, &pe.Sender, &pe.Timestamp, &content)
+	if err != nil {
+		return nil, err
+	}
+	pe.Type.Class = event.StateEventType
+	pe.Content = json.RawMessage(content)
+	return pe, nil
+}
@@ -0,0 +1,130 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"go.mau.fi/util/dbutil"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	getNextQueuedActionQuery = `
+		SELECT id, management_room, room_id, action_type, payload, attempts, next_attempt, created_at
+		FROM queued_action
+		WHERE management_room=$1 AND room_id=$2
+		ORDER BY CASE WHEN action_type='server_acl' THEN 0 ELSE 1 END, created_at, id
+		LIMIT 1
+	`
+	getQueuedBansAndUnbansQuery = `
+		SELECT id, management_room, room_id, action_type, payload, attempts, next_attempt, created_at
+		FROM queued_action
+		WHERE management_room=$1 AND room_id=$2 AND action_type IN ('ban', 'unban')
+		ORDER BY created_at, id
+	`
+	getQueuedActionRoomsQuery = `
+		SELECT DISTINCT room_id FROM queued_action WHERE management_room=$1
+	`
+	insertQueuedActionQuery = `
+		INSERT INTO queued_action (id, management_room, room_id, action_type, payload, attempts, next_attempt, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`
+	updateQueuedActionQuery = `
+		UPDATE queued_action SET payload=$2, attempts=$3, next_attempt=$4 WHERE id=$1
+	`
+	deleteQueuedActionQuery = `
+		DELETE FROM queued_action WHERE id=$1
+	`
+)
+
+type QueuedActionQuery struct {
+	*dbutil.QueryHelper[*QueuedAction]
+}
+
+// GetNext returns the oldest queued action in the given room, or nil if there are no queued actions.
+// Server ACL updates are returned before other actions, so that they don't wait behind large batches of redactions.
+func (qaq *QueuedActionQuery) GetNext(ctx context.Context, managementRoom, roomID id.RoomID) (*QueuedAction, error) {
+	return qaq.QueryOne(ctx, getNextQueuedActionQuery, managementRoom, roomID)
+}
+
+// GetBansAndUnbans returns all queued bans and unbans in the given room, oldest first.
+func (qaq *QueuedActionQuery) GetBansAndUnbans(ctx context.Context, managementRoom, roomID id.RoomID) ([]*QueuedAction, error) {
+	return qaq.QueryMany(ctx, getQueuedBansAndUnbansQuery, managementRoom, roomID)
+}
+
+// GetRooms returns all rooms which have queued actions from the given management room.
+func (qaq *QueuedActionQuery) GetRooms(ctx context.Context, managementRoom id.RoomID) ([]id.RoomID, error) {
+	rows, err := qaq.GetDB().Query(ctx, getQueuedActionRoomsQuery, managementRoom)
+	return dbutil.NewRowIterWithError(rows, dbutil.ScanSingleColumn[id.RoomID], err).AsList()
+}
+
+func (qaq *QueuedActionQuery) Insert(ctx context.Context, qa *QueuedAction) error {
+	return qaq.Exec(ctx, insertQueuedActionQuery, qa.sqlVariables()...)
+}
+
+// Update saves the payload and retry state of the given action.
+func (qaq *QueuedActionQuery) Update(ctx context.Context, qa *QueuedAction) error {
+	return qaq.Exec(ctx, updateQueuedActionQuery, qa.ID, dbutil.JSON{Data: qa.Payload}, qa.Attempts, qa.NextAttempt.UnixMilli())
+}
+
+func (qaq *QueuedActionQuery) Delete(ctx context.Context, actionID string) error {
+	return qaq.Exec(ctx, deleteQueuedActionQuery, actionID)
+}
+
+type QueuedActionType string
+
+const (
+	QueuedActionTypeBan       QueuedActionType = "ban"
+	QueuedActionTypeUnban     QueuedActionType = "unban"
+	QueuedActionTypeTempUnban QueuedActionType = "temp_unban"
+	QueuedActionTypeKick      QueuedActionType = "kick"
+	QueuedActionTypeRedact    QueuedActionType = "redact"
+	QueuedActionTypeServerACL QueuedActionType = "server_acl"
+)
+
+// QueuedActionPayload contains the parameters of a queued action. Which fields are used depends on the action type.
+type QueuedActionPayload struct {
+	UserID      id.UserID    `json:"user_id,omitempty"`
+	Reason      string       `json:"reason,omitempty"`
+	TakenAction *TakenAction `json:"taken_action,omitempty"`
+	EventIDs    []id.EventID `json:"event_ids,omitempty"`
+
+	RedactedCount int `json:"redacted_count,omitempty"`
+	FailedCount   int `json:"failed_count,omitempty"`
+	// Superseded is set when a temporary ban wasn't lifted because the user was banned by a policy in the meantime.
+	Superseded bool `json:"superseded,omitempty"`
+}
+
+// QueuedAction is a moderation action that is waiting to be sent to the homeserver.
+type QueuedAction struct {
+	ID             string
+	ManagementRoom id.RoomID
+	RoomID         id.RoomID
+	Type           QueuedActionType
+	Payload        *QueuedActionPayload
+	Attempts       int
+	NextAttempt    time.Time
+	CreatedAt      time.Time
+}
+
+func (qa *QueuedAction) sqlVariables() []any {
+	return []any{
+		qa.ID, qa.ManagementRoom, qa.RoomID, qa.Type, dbutil.JSON{Data: qa.Payload},
+		qa.Attempts, qa.NextAttempt.UnixMilli(), qa.CreatedAt.UnixNano(),
+	}
+}
+
+func (qa *QueuedAction) Scan(row dbutil.Scannable) (*QueuedAction, error) {
+	var nextAttempt, createdAt int64
+	qa.Payload = &QueuedActionPayload{}
+	err := row.Scan(
+		&qa.ID, &qa.ManagementRoom, &qa.RoomID, &qa.Type, dbutil.JSON{Data: qa.Payload},
+		&qa.Attempts, &nextAttempt, &createdAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	qa.NextAttempt = time.UnixMilli(nextAttempt)
+	qa.CreatedAt = time.Unix(0, createdAt)
+	return qa, nil
+}
@@ -0,0 +1,257 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mau.fi/util/dbutil"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	getReportBaseQuery = `
+		SELECT id, management_room, reporter, target_user, room_id, event_id, reason, created_at,
+		       status, resolved_by, resolved_at, notice_event_id
+		FROM report
+	`
+	getReportByIDQuery            = getReportBaseQuery + `WHERE management_room=$1 AND id=$2`
+	getReportByNoticeQuery        = getReportBaseQuery + `WHERE management_room=$1 AND notice_event_id=$2`
+	getRecentReportsByTargetQuery = getReportBaseQuery + `
+		WHERE management_room=$1 AND target_user=$2
+		ORDER BY created_at DESC
+		LIMIT $3
+	`
+	getOpenReportsByTargetSinceQuery = getReportBaseQuery + `
+		WHERE management_room=$1 AND target_user=$2 AND status='open' AND created_at>=$3
+		ORDER BY created_at
+	`
+	insertReportQuery = `
+		INSERT INTO report (
+			id, management_room, reporter, target_user, room_id, event_id, reason, created_at,
+			status, resolved_by, resolved_at, notice_event_id
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+	`
+	resolveReportQuery = `
+		UPDATE report SET status=$3, resolved_by=$4, resolved_at=$5
+		WHERE management_room=$1 AND id=$2 AND status='open'
+	`
+	resolveReportsByTargetQuery = `
+		UPDATE report SET status=$3, resolved_by=$4, resolved_at=$5
+		WHERE management_room=$1 AND target_user=$2 AND status='open'
+	`
+)
+
+type ReportQuery struct {
+	*dbutil.QueryHelper[*Report]
+}
+
+func (rq *ReportQuery) Insert(ctx context.Context, report *Report) error {
+	return rq.Exec(ctx, insertReportQuery, report.sqlVariables()...)
+}
+
+func (rq *ReportQuery) GetByID(ctx context.Context, managementRoom id.RoomID, reportID string) (*Report, error) {
+	return rq.QueryOne(ctx, getReportByIDQuery, managementRoom, reportID)
+}
+
+// GetByNotice returns the report whose notice in the management room has the given event ID.
+func (rq *ReportQuery) GetByNotice(ctx context.Context, managementRoom id.RoomID, eventID id.EventID) (*Report, error) {
+	return rq.QueryOne(ctx, getReportByNoticeQuery, managementRoom, eventID)
+}
+
+// GetRecentByTarget returns the newest reports about the given user, up to the given limit.
+func (rq *ReportQuery) GetRecentByTarget(ctx context.Context, managementRoom id.RoomID, userID id.UserID, limit int) ([]*Report, error) {
+	return rq.QueryMany(ctx, getRecentReportsByTargetQuery, managementRoom, userID, limit)
+}
+
+// GetOpenByTargetSince returns the open reports about the given user which were received after the given time,
+// oldest first.
+func (rq *ReportQuery) GetOpenByTargetSince(ctx context.Context, managementRoom id.RoomID, userID id.UserID, since time.Time) ([]*Report, error) {
+	return rq.QueryMany(ctx, getOpenReportsByTargetSinceQuery, managementRoom, userID, since.UnixMilli())
+}
+
+// ReportFilter contains the optional filters for ReportQuery.GetFiltered. Empty fields are not filtered.
+type ReportFilter struct {
+	Status     ReportStatus
+	TargetUser id.UserID
+	Reporter   id.UserID
+	RoomID     id.RoomID
+	// Limit is the maximum number of cases (see GroupReports) to return. All reports of each case are returned.
+	Limit int
+}
+
+// reportCaseKey is the SQL equivalent of how GroupReports groups reports into cases.
+const reportCaseKey = "(CASE WHEN target_user='' THEN id ELSE target_user END)"
+
+// GetFiltered returns reports matching the given filter, newest first.
+func (rq *ReportQuery) GetFiltered(ctx context.Context, managementRoom id.RoomID, filter *ReportFilter) ([]*Report, error) {
+	conditions := []string{"management_room=$1"}
+	args := []any{managementRoom}
+	addCondition := func(column string, value any) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf("%s=$%d", column, len(args)))
+	}
+	if filter.Status != "" {
+		addCondition("status", filter.Status)
+	}
+	if filter.TargetUser != "" {
+		addCondition("target_user", filter.TargetUser)
+	}
+	if filter.Reporter != "" {
+		addCondition("reporter", filter.Reporter)
+	}
+	if filter.RoomID != "" {
+		addCondition("room_id", filter.RoomID)
+	}
+	where := strings.Join(conditions, " AND ")
+	if filter.Limit > 0 {
+		// The limit is applied to cases rather than reports, so that cases aren't split in half
+		args = append(args, filter.Limit)
+		where += fmt.Sprintf(
+			" AND %[1]s IN (SELECT %[1]s FROM report WHERE %[2]s GROUP BY %[1]s ORDER BY MAX(created_at) DESC LIMIT $%[3]d)",
+			reportCaseKey, where, len(args),
+		)
+	}
+	return rq.QueryMany(ctx, getReportBaseQuery+"WHERE "+where+" ORDER BY created_at DESC", args...)
+}
+
+// Resolve marks the given report as resolved if it's open. If the report has a target user,
+// all other open reports against the same user are resolved too. The number of resolved reports is returned.
+func (rq *ReportQuery) Resolve(ctx context.Context, report *Report, status ReportStatus, resolvedBy id.UserID) (int64, error) {
+	query, key := resolveReportQuery, report.ID
+	if report.TargetUser != "" {
+		query, key = resolveReportsByTargetQuery, string(report.TargetUser)
+	}
+	res, err := rq.GetDB().Exec(ctx, query, report.ManagementRoom, key, status, resolvedBy, time.Now().UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// ResolveMany marks the given reports as resolved if they're open. Unlike Resolve, other reports against
+// the same user aren't affected. The number of resolved reports is returned.
+func (rq *ReportQuery) ResolveMany(ctx context.Context, managementRoom id.RoomID, reportIDs []string, status ReportStatus, resolvedBy id.UserID) (int64, error) {
+	if len(reportIDs) == 0 {
+		return 0, nil
+	}
+	args := []any{managementRoom, status, resolvedBy, time.Now().UnixMilli()}
+	placeholders := make([]string, len(reportIDs))
+	for i, reportID := range reportIDs {
+		args = append(args, reportID)
+		placeholders[i] = fmt.Sprintf("$%d", len(args))
+	}
+	query := fmt.Sprintf(
+		"UPDATE report SET status=$2, resolved_by=$3, resolved_at=$4 WHERE management_room=$1 AND status='open' AND id IN (%s)",
+		strings.Join(placeholders, ", "),
+	)
+	res, err := rq.GetDB().Exec(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+type ReportStatus string
+
+const (
+	ReportStatusOpen      ReportStatus = "open"
+	ReportStatusActioned  ReportStatus = "actioned"
+	ReportStatusDismissed ReportStatus = "dismissed"
+)
+
+type ReportType string
+
+const (
+	ReportTypeEvent ReportType = "event"
+	ReportTypeRoom  ReportType = "room"
+	ReportTypeUser  ReportType = "user"
+)
+
+// Report is a user's report of an event, room or user, received through the Matrix client API.
+type Report struct {
+	ID             string    `json:"id"`
+	ManagementRoom id.RoomID `json:"management_room"`
+	Reporter       id.UserID `json:"reporter"`
+	// TargetUser is the reported user or the sender of the reported event.
+	// It's empty for room reports and if the reported event couldn't be fetched.
+	TargetUser id.UserID `json:"target_user,omitempty"`
+	// RoomID is the room where the reported event was sent or the reported room. It's empty for user reports.
+	RoomID id.RoomID `json:"room_id,omitempty"`
+	// EventID is the reported event. It's empty for room and user reports.
+	EventID   id.EventID `json:"event_id,omitempty"`
+	Reason    string     `json:"reason"`
+	CreatedAt time.Time  `json:"created_at"`
+
+	Status     ReportStatus `json:"status"`
+	ResolvedBy id.UserID    `json:"resolved_by,omitempty"`
+	ResolvedAt time.Time    `json:"resolved_at"`
+	// NoticeEventID is the ID of the notice about the report that was sent to the management room.
+	NoticeEventID id.EventID `json:"notice_event_id,omitempty"`
+}
+
+// Type returns whether the report is about an event, a room or a user.
+func (r *Report) Type() ReportType {
+	switch {
+	case r.EventID != "":
+		return ReportTypeEvent
+	case r.RoomID != "":
+		return ReportTypeRoom
+	default:
+		return ReportTypeUser
+	}
+}
+
+func (r *Report) sqlVariables() []any {
+	var resolvedAt int64
+	if !r.ResolvedAt.IsZero() {
+		resolvedAt = r.ResolvedAt.UnixMilli()
+	}
+	return []any{
+		r.ID, r.ManagementRoom, r.Reporter, r.TargetUser, r.RoomID, r.EventID, r.Reason, r.CreatedAt.UnixMilli(),
+		r.Status, r.ResolvedBy, resolvedAt, r.NoticeEventID,
+	}
+}
+
+func (r *Report) Scan(row dbutil.Scannable) (*Report, error) {
+	var createdAt, resolvedAt int64
+	err := row.Scan(
+		&r.ID, &r.ManagementRoom, &r.Reporter, &r.TargetUser, &r.RoomID, &r.EventID, &r.Reason, &createdAt,
+		&r.Status, &r.ResolvedBy, &resolvedAt, &r.NoticeEventID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	r.CreatedAt = time.UnixMilli(createdAt)
+	if resolvedAt != 0 {
+		r.ResolvedAt = time.UnixMilli(resolvedAt)
+	}
+	return r, nil
+}
+
+// ReportCase is a group of reports against the same user.
+type ReportCase struct {
+	TargetUser id.UserID `json:"target_user,omitempty"`
+	Reports    []*Report `json:"reports"`
+}
+
+// GroupReports groups the given reports by target user, keeping the order of the first report of each group.
+// Reports without a target user are each put in their own case.
+func GroupReports(reports []*Report) []*ReportCase {
+	cases := make([]*ReportCase, 0)
+	byTarget := make(map[id.UserID]*ReportCase)
+	for _, report := range reports {
+		if rc, ok := byTarget[report.TargetUser]; ok && report.TargetUser != "" {
+			rc.Reports = append(rc.Reports, report)
+			continue
+		}
+		rc := &ReportCase{TargetUser: report.TargetUser, Reports: []*Report{report}}
+		cases = append(cases, rc)
+		if report.TargetUser != "" {
+			byTarget[report.TargetUser] = rc
+		}
+	}
+	return cases
+}
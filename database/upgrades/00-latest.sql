@@ -1,4 +1,4 @@
--- v0 -> v1 (compatible with v1+): Latest schema
+-- v0 -> v8 (compatible with v1+): Latest schema
 CREATE TABLE bot (
     username     TEXT PRIMARY KEY NOT NULL,
     displayname  TEXT NOT NULL,
@@ -19,6 +19,7 @@ CREATE TABLE taken_action (
     action_type TEXT   NOT NULL,
     policy_list TEXT   NOT NULL,
     rule_entity TEXT   NOT NULL,
+    rule_type   TEXT   NOT NULL,
     action      TEXT   NOT NULL,
     taken_at    BIGINT NOT NULL,
 
@@ -27,3 +28,51 @@ CREATE TABLE taken_action (
 
 CREATE INDEX taken_action_list_idx ON taken_action (policy_list);
 CREATE INDEX taken_action_entity_idx ON taken_action (policy_list, rule_entity);
+
+CREATE TABLE policy_event (
+    room_id    TEXT   NOT NULL,
+    event_type TEXT   NOT NULL,
+    state_key  TEXT   NOT NULL,
+    event_id   TEXT   NOT NULL,
+    sender     TEXT   NOT NULL,
+    timestamp  BIGINT NOT NULL,
+    content    TEXT   NOT NULL,
+
+    PRIMARY KEY (room_id, event_type, state_key)
+);
+
+CREATE TABLE policy_list_cache (
+    room_id   TEXT   PRIMARY KEY NOT NULL,
+    cached_at BIGINT NOT NULL
+);
+
+CREATE TABLE queued_action (
+    id              TEXT    PRIMARY KEY NOT NULL,
+    management_room TEXT    NOT NULL,
+    room_id         TEXT    NOT NULL,
+    action_type     TEXT    NOT NULL,
+    payload         TEXT    NOT NULL,
+    attempts        INTEGER NOT NULL,
+    next_attempt    BIGINT  NOT NULL,
+    created_at      BIGINT  NOT NULL
+);
+
+CREATE INDEX queued_action_room_idx ON queued_action (management_room, room_id, created_at);
+
+CREATE TABLE report (
+    id              TEXT   PRIMARY KEY NOT NULL,
+    management_room TEXT   NOT NULL,
+    reporter        TEXT   NOT NULL,
+    target_user     TEXT   NOT NULL,
+    room_id         TEXT   NOT NULL,
+    event_id        TEXT   NOT NULL,
+    reason          TEXT   NOT NULL,
+    created_at      BIGINT NOT NULL,
+    status          TEXT   NOT NULL,
+    resolved_by     TEXT   NOT NULL,
+    resolved_at     BIGINT NOT NULL,
+    notice_event_id TEXT   NOT NULL
+);
+
+CREATE INDEX report_target_idx ON report (management_room, target_user, created_at);
+CREATE INDEX report_status_idx ON report (management_room, status, created_at);
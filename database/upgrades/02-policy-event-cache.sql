@@ -0,0 +1,12 @@
+-- v2 (compatible with v1+): Add table for caching policy list state
+CREATE TABLE policy_event (
+    room_id    TEXT   NOT NULL,
+    event_type TEXT   NOT NULL,
+    state_key  TEXT   NOT NULL,
+    event_id   TEXT   NOT NULL,
+    sender     TEXT   NOT NULL,
+    timestamp  BIGINT NOT NULL,
+    content    TEXT   NOT NULL,
+
+    PRIMARY KEY (room_id, event_type, state_key)
+);
@@ -0,0 +1,2 @@
+-- v3 (compatible with v1+): Store the entity type of the rule that caused taken actions
+ALTER TABLE taken_action ADD COLUMN rule_type TEXT NOT NULL DEFAULT 'user';
@@ -0,0 +1,13 @@
+-- v4 (compatible with v1+): Add table for queued moderation actions
+CREATE TABLE queued_action (
+    id              TEXT    PRIMARY KEY NOT NULL,
+    management_room TEXT    NOT NULL,
+    room_id         TEXT    NOT NULL,
+    action_type     TEXT    NOT NULL,
+    payload         TEXT    NOT NULL,
+    attempts        INTEGER NOT NULL,
+    next_attempt    BIGINT  NOT NULL,
+    created_at      BIGINT  NOT NULL
+);
+
+CREATE INDEX queued_action_room_idx ON queued_action (management_room, room_id, created_at);
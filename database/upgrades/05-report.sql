@@ -0,0 +1,13 @@
+-- v5 (compatible with v1+): Add table for received reports
+CREATE TABLE report (
+    id              TEXT   PRIMARY KEY NOT NULL,
+    management_room TEXT   NOT NULL,
+    reporter        TEXT   NOT NULL,
+    target_user     TEXT   NOT NULL,
+    room_id         TEXT   NOT NULL,
+    event_id        TEXT   NOT NULL,
+    reason          TEXT   NOT NULL,
+    created_at      BIGINT NOT NULL
+);
+
+CREATE INDEX report_target_idx ON report (management_room, target_user, created_at);
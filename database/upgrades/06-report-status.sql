@@ -0,0 +1,6 @@
+-- v6 (compatible with v1+): Add triage status to reports
+ALTER TABLE report ADD COLUMN status TEXT NOT NULL DEFAULT 'open';
+ALTER TABLE report ADD COLUMN resolved_by TEXT NOT NULL DEFAULT '';
+ALTER TABLE report ADD COLUMN resolved_at BIGINT NOT NULL DEFAULT 0;
+
+CREATE INDEX report_status_idx ON report (management_room, status, created_at);
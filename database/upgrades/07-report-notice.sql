@@ -0,0 +1,2 @@
+-- v7 (compatible with v1+): Store the management room notice of reports
+ALTER TABLE report ADD COLUMN notice_event_id TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,5 @@
+-- v8 (compatible with v1+): Track which policy lists have been fully cached
+CREATE TABLE policy_list_cache (
+    room_id   TEXT   PRIMARY KEY NOT NULL,
+    cached_at BIGINT NOT NULL
+);
@@ -0,0 +1,339 @@
+package policyeval
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.mau.fi/util/random"
+	"go.mau.fi/util/retryafter"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/meowlnir/database"
+	"go.mau.fi/meowlnir/policylist"
+)
+
+const (
+	maxServerErrorAttempts = 10
+	maxQueueBackoff        = 5 * time.Minute
+)
+
+// queueAction stores the given action in the database and wakes up the worker for the room.
+//
+// Actions are executed in order for each room, but different rooms are processed in parallel.
+// Server ACL updates are the exception, they're executed before any other actions queued in the room.
+func (pe *PolicyEvaluator) queueAction(ctx context.Context, roomID id.RoomID, actionType database.QueuedActionType, payload *database.QueuedActionPayload) error {
+	return pe.queueActionAt(ctx, roomID, actionType, payload, time.Now())
+}
+
+// queueActionAt queues an action that will be executed at the given time. The action is ordered by the time
+// it's scheduled for, so actions scheduled in the future don't block other actions queued before then.
+func (pe *PolicyEvaluator) queueActionAt(ctx context.Context, roomID id.RoomID, actionType database.QueuedActionType, payload *database.QueuedActionPayload, at time.Time) error {
+	qa := &database.QueuedAction{
+		ID:             random.String(16),
+		ManagementRoom: pe.ManagementRoom,
+		RoomID:         roomID,
+		Type:           actionType,
+		Payload:        payload,
+		NextAttempt:    at,
+		CreatedAt:      at,
+	}
+	err := pe.DB.QueuedAction.Insert(ctx, qa)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).
+			Stringer("room_id", roomID).
+			Str("action_type", string(actionType)).
+			Msg("Failed to queue action")
+		pe.sendNotice(ctx, "Failed to queue %s action in [%s](%s): %v", actionType, roomID, roomID.URI().MatrixToURL(), err)
+		return err
+	}
+	pe.wakeQueueWorker(roomID)
+	return nil
+}
+
+// ResumeQueuedActions starts workers for all rooms that have pending actions in the database.
+func (pe *PolicyEvaluator) ResumeQueuedActions(ctx context.Context) {
+	rooms, err := pe.DB.QueuedAction.GetRooms(ctx, pe.ManagementRoom)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to get rooms with queued actions")
+		pe.sendNotice(ctx, "Failed to resume queued actions: %v", err)
+		return
+	}
+	for _, roomID := range rooms {
+		pe.wakeQueueWorker(roomID)
+	}
+	if len(rooms) > 0 {
+		zerolog.Ctx(ctx).Info().Int("room_count", len(rooms)).Msg("Resumed queued actions")
+	}
+}
+
+func (pe *PolicyEvaluator) wakeQueueWorker(roomID id.RoomID) {
+	pe.queueLock.Lock()
+	defer pe.queueLock.Unlock()
+	wake, ok := pe.queueWorkers[roomID]
+	if !ok {
+		wake = make(chan struct{}, 1)
+		pe.queueWorkers[roomID] = wake
+		go pe.queueWorker(roomID, wake)
+	}
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+}
+
+func (pe *PolicyEvaluator) queueWorker(roomID id.RoomID, wake chan struct{}) {
+	log := pe.Bot.Log.With().
+		Stringer("management_room", pe.ManagementRoom).
+		Stringer("room_id", roomID).
+		Str("action", "action queue").
+		Logger()
+	ctx := log.WithContext(context.Background())
+	for {
+		qa, err := pe.DB.QueuedAction.GetNext(ctx, pe.ManagementRoom, roomID)
+		if err != nil {
+			log.Err(err).Msg("Failed to get next queued action")
+			time.Sleep(10 * time.Second)
+			continue
+		} else if qa == nil {
+			pe.queueLock.Lock()
+			select {
+			case <-wake:
+				pe.queueLock.Unlock()
+				continue
+			default:
+				delete(pe.queueWorkers, roomID)
+				pe.queueLock.Unlock()
+				return
+			}
+		}
+		if wait := time.Until(qa.NextAttempt); wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-wake:
+				// A new action may have been queued before this one
+				continue
+			}
+		}
+		pe.runQueuedAction(ctx, qa)
+	}
+}
+
+func (pe *PolicyEvaluator) runQueuedAction(ctx context.Context, qa *database.QueuedAction) {
+	log := zerolog.Ctx(ctx).With().
+		Str("queued_action_id", qa.ID).
+		Str("action_type", string(qa.Type)).
+		Logger()
+	ctx = log.WithContext(ctx)
+	err := pe.executeQueuedAction(ctx, qa)
+	if err != nil {
+		var respErr mautrix.HTTPError
+		if errors.As(err, &respErr) {
+			err = respErr
+		}
+		if delay, retry := queueRetryDelay(err, qa.Attempts); retry {
+			qa.Attempts++
+			qa.NextAttempt = time.Now().Add(delay)
+			log.Warn().Err(err).
+				Int("attempts", qa.Attempts).
+				Dur("retry_in", delay).
+				Msg("Failed to execute queued action, retrying later")
+			err = pe.DB.QueuedAction.Update(ctx, qa)
+			if err != nil {
+				log.Err(err).Msg("Failed to update queued action")
+			}
+			return
+		}
+		log.Err(err).Any("payload", qa.Payload).Msg("Failed to execute queued action")
+		pe.handleFailedAction(ctx, qa, err)
+	} else {
+		pe.handleCompletedAction(ctx, qa)
+	}
+	err = pe.DB.QueuedAction.Delete(ctx, qa.ID)
+	if err != nil {
+		log.Err(err).Msg("Failed to delete completed action from queue")
+	}
+}
+
+// queueRetryDelay checks if the given error is a rate limit or a temporary server error,
+// and returns how long to wait before retrying.
+func queueRetryDelay(err error, attempts int) (time.Duration, bool) {
+	backoff := min(time.Duration(1<<min(attempts, 16))*time.Second, maxQueueBackoff)
+	var httpErr mautrix.HTTPError
+	if !errors.As(err, &httpErr) {
+		return 0, false
+	} else if httpErr.RespError != nil && httpErr.RespError.ErrCode == mautrix.MLimitExceeded.ErrCode {
+		// Rate limits are always retried, the server tells us how long to wait
+		if retryAfterMS, ok := httpErr.RespError.ExtraData["retry_after_ms"].(float64); ok && retryAfterMS > 0 {
+			return time.Duration(retryAfterMS) * time.Millisecond, true
+		} else if httpErr.Response != nil {
+			return retryafter.Parse(httpErr.Response.Header.Get("Retry-After"), backoff), true
+		}
+		return backoff, true
+	} else if attempts+1 >= maxServerErrorAttempts {
+		return 0, false
+	} else if httpErr.Response == nil || httpErr.Response.StatusCode >= 500 {
+		// No response means the request failed at the network level, which is also worth retrying
+		return backoff, true
+	}
+	return 0, false
+}
+
+func (pe *PolicyEvaluator) executeQueuedAction(ctx context.Context, qa *database.QueuedAction) (err error) {
+	switch qa.Type {
+	case database.QueuedActionTypeBan:
+		if !pe.DryRun {
+			_, err = pe.Bot.BanUser(ctx, qa.RoomID, &mautrix.ReqBanUser{
+				Reason: qa.Payload.Reason,
+				UserID: qa.Payload.UserID,
+			})
+		}
+	case database.QueuedActionTypeUnban:
+		if !pe.DryRun {
+			_, err = pe.Bot.UnbanUser(ctx, qa.RoomID, &mautrix.ReqUnbanUser{
+				Reason: qa.Payload.Reason,
+				UserID: qa.Payload.UserID,
+			})
+		}
+	case database.QueuedActionTypeTempUnban:
+		err = pe.liftTempBan(ctx, qa)
+	case database.QueuedActionTypeKick:
+		if !pe.DryRun {
+			_, err = pe.Bot.KickUser(ctx, qa.RoomID, &mautrix.ReqKickUser{
+				Reason: qa.Payload.Reason,
+				UserID: qa.Payload.UserID,
+			})
+		}
+	case database.QueuedActionTypeRedact:
+		err = pe.redactQueuedEvents(ctx, qa)
+	case database.QueuedActionTypeServerACL:
+		err = pe.updateACLInRoom(ctx, qa.RoomID, pe.CompileACL())
+	default:
+		err = fmt.Errorf("unknown action type %q", qa.Type)
+	}
+	return
+}
+
+// redactQueuedEvents redacts the events in the payload one by one, removing them from the payload as they're handled.
+// If a retryable error occurs, the remaining events are left in the payload so that they're saved for the next attempt.
+func (pe *PolicyEvaluator) redactQueuedEvents(ctx context.Context, qa *database.QueuedAction) error {
+	log := zerolog.Ctx(ctx).With().Stringer("sender", qa.Payload.UserID).Logger()
+	for len(qa.Payload.EventIDs) > 0 {
+		evtID := qa.Payload.EventIDs[0]
+		var resp *mautrix.RespSendEvent
+		var err error
+		if !pe.DryRun {
+			resp, err = pe.Bot.RedactEvent(ctx, qa.RoomID, evtID, mautrix.ReqRedact{Reason: qa.Payload.Reason})
+		} else {
+			resp = &mautrix.RespSendEvent{EventID: "$fake-redaction-id"}
+		}
+		if err != nil {
+			if _, retry := queueRetryDelay(err, qa.Attempts); retry {
+				return err
+			}
+			log.Err(err).Stringer("event_id", evtID).Msg("Failed to redact event")
+			qa.Payload.FailedCount++
+		} else {
+			log.Debug().
+				Stringer("event_id", evtID).
+				Stringer("redaction_id", resp.EventID).
+				Msg("Successfully redacted event")
+			qa.Payload.RedactedCount++
+		}
+		qa.Payload.EventIDs = qa.Payload.EventIDs[1:]
+	}
+	return nil
+}
+
+func (pe *PolicyEvaluator) handleCompletedAction(ctx context.Context, qa *database.QueuedAction) {
+	userID := qa.Payload.UserID
+	roomID := qa.RoomID
+	switch qa.Type {
+	case database.QueuedActionTypeBan:
+		ta := qa.Payload.TakenAction
+		if ta == nil {
+			// Temporary bans aren't based on policies, so there's no taken action to save
+			zerolog.Ctx(ctx).Info().Stringer("user_id", userID).Msg("Temporarily banned user")
+			pe.sendNotice(ctx, "Temporarily banned [%s](%s) in [%s](%s) for %s", userID, userID.URI().MatrixToURL(), roomID, roomID.URI().MatrixToURL(), qa.Payload.Reason)
+			break
+		}
+		var suffix string
+		if ta.RuleType == string(policylist.EntityTypeServer) {
+			suffix = fmt.Sprintf(" (server rule `%s`)", ta.RuleEntity)
+		}
+		err := pe.DB.TakenAction.Put(ctx, ta)
+		if err != nil {
+			zerolog.Ctx(ctx).Err(err).Any("taken_action", ta).Msg("Failed to save taken action")
+			pe.sendNotice(ctx, "Banned [%s](%s) in [%s](%s) for %s%s, but failed to save to database: %v", userID, userID.URI().MatrixToURL(), roomID, roomID.URI().MatrixToURL(), qa.Payload.Reason, suffix, err)
+		} else {
+			zerolog.Ctx(ctx).Info().Any("taken_action", ta).Msg("Took action")
+			pe.sendNotice(ctx, "Banned [%s](%s) in [%s](%s) for %s%s", userID, userID.URI().MatrixToURL(), roomID, roomID.URI().MatrixToURL(), qa.Payload.Reason, suffix)
+		}
+	case database.QueuedActionTypeUnban:
+		ta := qa.Payload.TakenAction
+		ta.Action = event.PolicyRecommendationUnban
+		ta.TakenAt = time.Now()
+		err := pe.DB.TakenAction.Put(ctx, ta)
+		if err != nil {
+			zerolog.Ctx(ctx).Err(err).Any("taken_action", ta).Msg("Failed to save taken action")
+			pe.sendNotice(ctx, "Unbanned [%s](%s) in [%s](%s) (%s), but failed to save to database: %v", userID, userID.URI().MatrixToURL(), roomID, roomID.URI().MatrixToURL(), qa.Payload.Reason, err)
+		} else {
+			zerolog.Ctx(ctx).Info().Any("taken_action", ta).Msg("Took action")
+			pe.sendNotice(ctx, "Unbanned [%s](%s) in [%s](%s) (%s)", userID, userID.URI().MatrixToURL(), roomID, roomID.URI().MatrixToURL(), qa.Payload.Reason)
+		}
+	case database.QueuedActionTypeTempUnban:
+		if qa.Payload.Superseded {
+			pe.sendNotice(ctx, "Not lifting temporary ban of [%s](%s) in [%s](%s), as they've been banned by a policy since", userID, userID.URI().MatrixToURL(), roomID, roomID.URI().MatrixToURL())
+		} else {
+			zerolog.Ctx(ctx).Info().Stringer("user_id", userID).Msg("Lifted temporary ban")
+			pe.sendNotice(ctx, "Lifted temporary ban of [%s](%s) in [%s](%s)", userID, userID.URI().MatrixToURL(), roomID, roomID.URI().MatrixToURL())
+		}
+	case database.QueuedActionTypeKick:
+		zerolog.Ctx(ctx).Info().Stringer("user_id", userID).Msg("Kicked user")
+		var suffix string
+		if qa.Payload.Reason != "" {
+			suffix = " for " + qa.Payload.Reason
+		}
+		pe.sendNotice(ctx, "Kicked [%s](%s) from [%s](%s)%s", userID, userID.URI().MatrixToURL(), roomID, roomID.URI().MatrixToURL(), suffix)
+	case database.QueuedActionTypeRedact:
+		zerolog.Ctx(ctx).Info().
+			Stringer("sender", userID).
+			Int("redacted_count", qa.Payload.RedactedCount).
+			Int("failed_count", qa.Payload.FailedCount).
+			Msg("Finished redacting events")
+		if qa.Payload.FailedCount > 0 {
+			pe.sendNotice(ctx,
+				"Failed to redact %d/%d events from [%s](%s) in [%s](%s)",
+				qa.Payload.FailedCount, qa.Payload.FailedCount+qa.Payload.RedactedCount,
+				userID, userID.URI().MatrixToURL(), roomID, roomID.URI().MatrixToURL())
+		}
+	}
+}
+
+func (pe *PolicyEvaluator) handleFailedAction(ctx context.Context, qa *database.QueuedAction, err error) {
+	userID := qa.Payload.UserID
+	roomID := qa.RoomID
+	switch qa.Type {
+	case database.QueuedActionTypeBan:
+		pe.sendNotice(ctx, "Failed to ban [%s](%s) in [%s](%s) for %s: %v", userID, userID.URI().MatrixToURL(), roomID, roomID.URI().MatrixToURL(), qa.Payload.Reason, err)
+	case database.QueuedActionTypeUnban:
+		pe.sendNotice(ctx, "Failed to unban [%s](%s) in [%s](%s): %v", userID, userID.URI().MatrixToURL(), roomID, roomID.URI().MatrixToURL(), err)
+	case database.QueuedActionTypeTempUnban:
+		pe.sendNotice(ctx, "Failed to lift temporary ban of [%s](%s) in [%s](%s): %v", userID, userID.URI().MatrixToURL(), roomID, roomID.URI().MatrixToURL(), err)
+	case database.QueuedActionTypeKick:
+		pe.sendNotice(ctx, "Failed to kick [%s](%s) from [%s](%s): %v", userID, userID.URI().MatrixToURL(), roomID, roomID.URI().MatrixToURL(), err)
+	case database.QueuedActionTypeRedact:
+		pe.sendNotice(ctx,
+			"Failed to redact %d/%d events from [%s](%s) in [%s](%s): %v",
+			len(qa.Payload.EventIDs)+qa.Payload.FailedCount, len(qa.Payload.EventIDs)+qa.Payload.FailedCount+qa.Payload.RedactedCount,
+			userID, userID.URI().MatrixToURL(), roomID, roomID.URI().MatrixToURL(), err)
+	case database.QueuedActionTypeServerACL:
+		pe.sendNotice(ctx, "Failed to update server ACL in [%s](%s): %v", roomID, roomID.URI().MatrixToURL(), err)
+	default:
+		pe.sendNotice(ctx, "Failed to execute %s action in [%s](%s): %v", qa.Type, roomID, roomID.URI().MatrixToURL(), err)
+	}
+}
@@ -0,0 +1,257 @@
+package policyeval
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix/event"
+
+	"go.mau.fi/meowlnir/bot"
+)
+
+const commandPrefix = "!"
+
+// CommandHandler describes a single management room command.
+type CommandHandler struct {
+	Name        string
+	Aliases     []string
+	Usage       string
+	Description string
+	// MinArgs is the minimum number of positional arguments (not including flags) the command needs.
+	MinArgs int
+	// Flags lists the names of boolean `--flag` arguments the command accepts.
+	Flags []string
+	// Subcommands are dispatched based on the first argument. If set, Func is not called.
+	Subcommands []*CommandHandler
+	Func        func(pe *PolicyEvaluator, ctx context.Context, ce *CommandEvent)
+
+	parent *CommandHandler
+}
+
+// FullName returns the name of the command including the names of parent commands.
+func (ch *CommandHandler) FullName() string {
+	if ch.parent != nil {
+		return ch.parent.FullName() + " " + ch.Name
+	}
+	return commandPrefix + ch.Name
+}
+
+// FormatUsage returns the usage text of the command formatted as inline code.
+func (ch *CommandHandler) FormatUsage() string {
+	if ch.Usage == "" {
+		return fmt.Sprintf("`%s`", ch.FullName())
+	}
+	return fmt.Sprintf("`%s %s`", ch.FullName(), ch.Usage)
+}
+
+func (ch *CommandHandler) formatHelp() string {
+	if len(ch.Subcommands) == 0 {
+		return fmt.Sprintf("* %s - %s", ch.FormatUsage(), ch.Description)
+	}
+	lines := []string{fmt.Sprintf("* `%s` - %s", ch.FullName(), ch.Description)}
+	for _, sub := range ch.Subcommands {
+		lines = append(lines, "  "+sub.formatHelp())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (ch *CommandHandler) findSubcommand(name string) *CommandHandler {
+	for _, sub := range ch.Subcommands {
+		if sub.Name == name || slices.Contains(sub.Aliases, name) {
+			return sub
+		}
+	}
+	return nil
+}
+
+// CommandEvent contains a parsed command and helpers for responding to it.
+type CommandEvent struct {
+	Event   *event.Event
+	Handler *CommandHandler
+	Args    []string
+	Flags   map[string]bool
+
+	pe *PolicyEvaluator
+}
+
+// Reply sends a notice to the management room.
+func (ce *CommandEvent) Reply(ctx context.Context, message string, args ...any) {
+	ce.pe.sendNotice(ctx, message, args...)
+}
+
+// ReplyError sends a notice in a thread under the command message.
+func (ce *CommandEvent) ReplyError(ctx context.Context, message string, args ...any) {
+	if len(args) > 0 {
+		message = fmt.Sprintf(message, args...)
+	}
+	ce.pe.Bot.SendNoticeOpts(ctx, ce.pe.ManagementRoom, message, &bot.SendNoticeOpts{
+		RelatesTo: threadRelation(ce.Event),
+	})
+}
+
+// ReplyUsage sends the usage of the command in a thread under the command message.
+func (ce *CommandEvent) ReplyUsage(ctx context.Context) {
+	ce.ReplyError(ctx, "Usage: %s", ce.Handler.FormatUsage())
+}
+
+// React sends a ✅ reaction to the command message.
+func (ce *CommandEvent) React(ctx context.Context) {
+	ce.pe.sendSuccessReaction(ctx, ce.Event.ID)
+}
+
+func threadRelation(evt *event.Event) *event.RelatesTo {
+	threadRoot := evt.ID
+	if msg, ok := evt.Content.Parsed.(*event.MessageEventContent); ok {
+		if parent := msg.RelatesTo.GetThreadParent(); parent != "" {
+			threadRoot = parent
+		}
+	}
+	return (&event.RelatesTo{}).SetThread(threadRoot, evt.ID)
+}
+
+var (
+	commands     []*CommandHandler
+	commandsByID = make(map[string]*CommandHandler)
+)
+
+func registerCommands(handlers ...*CommandHandler) {
+	for _, handler := range handlers {
+		setCommandParents(handler)
+		commands = append(commands, handler)
+		commandsByID[handler.Name] = handler
+		for _, alias := range handler.Aliases {
+			commandsByID[alias] = handler
+		}
+	}
+}
+
+func setCommandParents(handler *CommandHandler) {
+	for _, sub := range handler.Subcommands {
+		sub.parent = handler
+		setCommandParents(sub)
+	}
+}
+
+var errUnterminatedQuote = errors.New("unterminated quote")
+
+// parseCommandArgs splits the input into arguments like a shell would.
+// Single and double quotes at the start of an argument can be used to include spaces in it,
+// and backslashes escape the next character.
+func parseCommandArgs(input string) ([]string, error) {
+	var args []string
+	var current strings.Builder
+	var quote rune
+	inArg := false
+	escaped := false
+	for _, char := range input {
+		switch {
+		case escaped:
+			current.WriteRune(char)
+			escaped = false
+		case char == '\\':
+			escaped = true
+			inArg = true
+		case quote != 0:
+			if char == quote {
+				quote = 0
+			} else {
+				current.WriteRune(char)
+			}
+		case (char == '"' || char == '\'') && !inArg:
+			// Quotes are only special at the start of an argument, so that words like "don't" work without escaping
+			quote = char
+			inArg = true
+		case char == ' ' || char == '\t' || char == '\n':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(char)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, errUnterminatedQuote
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
+
+// HandleCommand parses a message in the management room and runs the command in it.
+func (pe *PolicyEvaluator) HandleCommand(ctx context.Context, evt *event.Event) {
+	content, ok := evt.Content.Parsed.(*event.MessageEventContent)
+	if !ok || !strings.HasPrefix(content.Body, commandPrefix) {
+		return
+	}
+	ce := &CommandEvent{Event: evt, Flags: make(map[string]bool), pe: pe}
+	args, err := parseCommandArgs(strings.TrimPrefix(content.Body, commandPrefix))
+	if err != nil {
+		ce.ReplyError(ctx, "Failed to parse command: %v", err)
+		return
+	} else if len(args) == 0 {
+		return
+	}
+	handler, ok := commandsByID[strings.ToLower(args[0])]
+	if !ok {
+		ce.ReplyError(ctx, "Unknown command `%s`. Use `%shelp` to see available commands.", args[0], commandPrefix)
+		return
+	}
+	args = args[1:]
+	for len(handler.Subcommands) > 0 {
+		var sub *CommandHandler
+		if len(args) > 0 {
+			sub = handler.findSubcommand(strings.ToLower(args[0]))
+		}
+		if sub == nil {
+			ce.ReplyError(ctx, "Usage:\n\n%s", handler.formatHelp())
+			return
+		}
+		handler = sub
+		args = args[1:]
+	}
+	ce.Handler = handler
+	ce.Args = make([]string, 0, len(args))
+	for _, arg := range args {
+		if flagName, isFlag := strings.CutPrefix(arg, "--"); isFlag && slices.Contains(handler.Flags, flagName) {
+			ce.Flags[flagName] = true
+		} else {
+			ce.Args = append(ce.Args, arg)
+		}
+	}
+	if len(ce.Args) < handler.MinArgs {
+		ce.ReplyUsage(ctx)
+		return
+	}
+	zerolog.Ctx(ctx).Info().Str("command", handler.FullName()).Msg("Handling command")
+	handler.Func(pe, ctx, ce)
+}
+
+var helpCommand = &CommandHandler{
+	Name:        "help",
+	Usage:       "[command]",
+	Description: "Show this help message, or the usage of a specific command.",
+	Func: func(pe *PolicyEvaluator, ctx context.Context, ce *CommandEvent) {
+		if len(ce.Args) > 0 {
+			handler, ok := commandsByID[strings.ToLower(strings.TrimPrefix(ce.Args[0], commandPrefix))]
+			if !ok {
+				ce.ReplyError(ctx, "Unknown command `%s`", ce.Args[0])
+				return
+			}
+			ce.Reply(ctx, handler.formatHelp())
+			return
+		}
+		lines := make([]string, len(commands))
+		for i, handler := range commands {
+			lines[i] = handler.formatHelp()
+		}
+		ce.Reply(ctx, "Available commands:\n\n%s", strings.Join(lines, "\n"))
+	},
+}
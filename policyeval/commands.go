@@ -4,104 +4,370 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/rs/zerolog"
+	"go.mau.fi/util/random"
 	"maunium.net/go/mautrix"
 	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/id"
 
+	"go.mau.fi/meowlnir/config"
+	"go.mau.fi/meowlnir/database"
 	"go.mau.fi/meowlnir/policylist"
 )
 
-func (pe *PolicyEvaluator) HandleCommand(ctx context.Context, evt *event.Event) {
-	fields := strings.Fields(evt.Content.AsMessage().Body)
-	cmd := fields[0]
-	args := fields[1:]
-	zerolog.Ctx(ctx).Info().Str("command", cmd).Msg("Handling command")
-	switch strings.ToLower(cmd) {
-	case "!join":
-		for _, arg := range args {
-			_, err := pe.Bot.JoinRoom(ctx, arg, "", nil)
-			if err != nil {
-				pe.sendNotice(ctx, "Failed to join room %q: %v", arg, err)
-			} else {
-				pe.sendNotice(ctx, "Joined room %q", arg)
-			}
-		}
-		pe.sendSuccessReaction(ctx, evt.ID)
-	case "!redact":
-		if len(args) < 1 {
-			pe.sendNotice(ctx, "Usage: `!redact <user ID> [reason]`")
-			return
+func init() {
+	registerCommands(
+		helpCommand,
+		&CommandHandler{
+			Name:        "join",
+			Usage:       "<room ID or alias>...",
+			Description: "Join the given rooms.",
+			MinArgs:     1,
+			Func:        (*PolicyEvaluator).cmdJoin,
+		},
+		&CommandHandler{
+			Name:        "redact",
+			Usage:       "<user ID> [reason]",
+			Description: "Redact all messages from the user in protected rooms.",
+			MinArgs:     1,
+			Func:        (*PolicyEvaluator).cmdRedact,
+		},
+		&CommandHandler{
+			Name:        "ban",
+			Usage:       "<list shortcode> <user ID> [duration] <reason>",
+			Description: "Send a ban policy for the user to the given list, optionally expiring after the duration (e.g. `7d`).",
+			MinArgs:     2,
+			Func:        (*PolicyEvaluator).cmdBan,
+		},
+		&CommandHandler{
+			Name:        "unban",
+			Usage:       "<list shortcode> <entity>",
+			Description: "Remove ban policies for the entity from the given list and lift the bans Meowlnir placed because of them.",
+			MinArgs:     2,
+			Func:        (*PolicyEvaluator).cmdUnban,
+		},
+		&CommandHandler{
+			Name:        "kick",
+			Usage:       "<user ID> [reason]",
+			Description: "Kick the user from all protected rooms they're in without creating a policy.",
+			MinArgs:     1,
+			Func:        (*PolicyEvaluator).cmdKick,
+		},
+	)
+}
+
+func (pe *PolicyEvaluator) cmdJoin(ctx context.Context, ce *CommandEvent) {
+	for _, arg := range ce.Args {
+		_, err := pe.Bot.JoinRoom(ctx, arg, "", nil)
+		if err != nil {
+			ce.ReplyError(ctx, "Failed to join room %q: %v", arg, err)
+		} else {
+			ce.Reply(ctx, "Joined room %q", arg)
 		}
-		pe.RedactUser(ctx, id.UserID(args[0]), strings.Join(args[1:], " "), false)
-		pe.sendSuccessReaction(ctx, evt.ID)
-	case "!ban":
-		if len(args) < 2 {
-			pe.sendNotice(ctx, "Usage: `!ban <list shortcode> <user ID> <reason>`")
+	}
+	ce.React(ctx)
+}
+
+func (pe *PolicyEvaluator) cmdRedact(ctx context.Context, ce *CommandEvent) {
+	pe.RedactUser(ctx, id.UserID(ce.Args[0]), strings.Join(ce.Args[1:], " "), false)
+	ce.React(ctx)
+}
+
+func (pe *PolicyEvaluator) cmdBan(ctx context.Context, ce *CommandEvent) {
+	list := pe.FindListByShortcode(ce.Args[0])
+	if list == nil {
+		ce.ReplyError(ctx, `List %q not found`, ce.Args[0])
+		return
+	}
+	target := ce.Args[1]
+	match := pe.Store.MatchUser(pe.GetWatchedLists(), id.UserID(target))
+	var existingStateKey string
+	if rec := match.Recommendations().BanOrUnban; rec != nil {
+		if rec.Recommendation == event.PolicyRecommendationUnban {
+			ce.ReplyError(ctx, "%s has an unban recommendation: %s", target, rec.Reason)
 			return
+		} else if rec.RoomID == list.RoomID {
+			existingStateKey = rec.StateKey
 		}
-		list := pe.FindListByShortcode(args[0])
-		if list == nil {
-			pe.sendNotice(ctx, `List %q not found`, args[0])
+	}
+	reasonArgs := ce.Args[2:]
+	var expiry time.Duration
+	if len(reasonArgs) > 0 {
+		var ok bool
+		var err error
+		if expiry, ok, err = parseDuration(reasonArgs[0]); err != nil {
+			ce.ReplyError(ctx, "Invalid expiry `%s`: %v", reasonArgs[0], err)
 			return
+		} else if ok {
+			reasonArgs = reasonArgs[1:]
 		}
-		target := args[1]
-		match := pe.Store.MatchUser(pe.GetWatchedLists(), id.UserID(target))
-		var existingStateKey string
-		if rec := match.Recommendations().BanOrUnban; rec != nil {
-			if rec.Recommendation == event.PolicyRecommendationUnban {
-				pe.sendNotice(ctx, "%s has an unban recommendation: %s", target, rec.Reason)
-				return
-			} else if rec.RoomID == list.RoomID {
-				existingStateKey = rec.StateKey
-			}
-		}
-		policy := &event.ModPolicyContent{
-			Entity:         target,
-			Reason:         strings.Join(args[2:], " "),
-			Recommendation: event.PolicyRecommendationBan,
-		}
-		resp, err := pe.SendPolicy(ctx, list.RoomID, policylist.EntityTypeUser, existingStateKey, policy)
+	}
+	policy := &event.ModPolicyContent{
+		Entity:         target,
+		Reason:         strings.Join(reasonArgs, " "),
+		Recommendation: event.PolicyRecommendationBan,
+	}
+	resp, err := pe.SendPolicy(ctx, list.RoomID, policylist.EntityTypeUser, existingStateKey, policy, expiry)
+	if err != nil {
+		ce.ReplyError(ctx, `Failed to send ban policy: %v`, err)
+		return
+	}
+	zerolog.Ctx(ctx).Info().
+		Stringer("policy_list", list.RoomID).
+		Any("policy", policy).
+		Stringer("policy_event_id", resp.EventID).
+		Msg("Sent ban policy from command")
+	ce.React(ctx)
+}
+
+func (pe *PolicyEvaluator) cmdUnban(ctx context.Context, ce *CommandEvent) {
+	list := pe.FindListByShortcode(ce.Args[0])
+	if list == nil {
+		ce.ReplyError(ctx, `List %q not found`, ce.Args[0])
+		return
+	}
+	room := pe.Store.GetRoom(list.RoomID)
+	if room == nil {
+		ce.ReplyError(ctx, "List %s is not loaded", list.Name)
+		return
+	}
+	entity := ce.Args[1]
+	policies := slices.DeleteFunc(room.FindByEntity(entity), func(policy *policylist.Policy) bool {
+		return !policylist.IsBanRecommendation(policy.Recommendation)
+	})
+	if len(policies) == 0 {
+		ce.ReplyError(ctx, "No ban policies for `%s` found in %s", entity, list.Name)
+		return
+	}
+	var actions []*database.TakenAction
+	for _, policy := range policies {
+		resp, err := pe.Bot.SendStateEvent(ctx, list.RoomID, policy.Type, policy.StateKey, map[string]any{})
 		if err != nil {
-			pe.sendNotice(ctx, `Failed to send ban policy: %v`, err)
+			ce.ReplyError(ctx, "Failed to remove policy for `%s` from %s: %v", policy.EntityOrHash(), list.Name, err)
 			return
 		}
 		zerolog.Ctx(ctx).Info().
 			Stringer("policy_list", list.RoomID).
-			Any("policy", policy).
+			Str("policy_state_key", policy.StateKey).
 			Stringer("policy_event_id", resp.EventID).
-			Msg("Sent ban policy from report")
-		pe.sendSuccessReaction(ctx, evt.ID)
-	case "!match":
-		start := time.Now()
-		match := pe.Store.MatchUser(nil, id.UserID(args[0]))
-		dur := time.Since(start)
-		if match != nil {
-			eventStrings := make([]string, len(match))
-			for i, policy := range match {
-				eventStrings[i] = fmt.Sprintf("* [%s](%s) set recommendation `%s` for `%s` at %s for %s",
-					policy.Sender, policy.Sender.URI().MatrixToURL(), policy.Recommendation, policy.Entity, time.UnixMilli(policy.Timestamp), policy.Reason)
+			Msg("Removed ban policy from command")
+		// Apply the removal immediately instead of waiting for it to come back through sync,
+		// so that the taken actions below are re-evaluated against the updated list.
+		stateKey := policy.StateKey
+		pe.updatePolicyList(ctx, &event.Event{
+			RoomID:    list.RoomID,
+			Type:      policy.Type,
+			StateKey:  &stateKey,
+			ID:        resp.EventID,
+			Sender:    pe.Bot.UserID,
+			Timestamp: time.Now().UnixMilli(),
+			Content:   event.Content{VeryRaw: json.RawMessage("{}"), Parsed: &event.ModPolicyContent{}},
+		})
+		if !list.AutoUnban {
+			policyActions, err := pe.DB.TakenAction.GetAllByRuleEntity(ctx, list.RoomID, policy.EntityOrHash())
+			if err != nil {
+				ce.ReplyError(ctx, "Failed to get bans placed because of `%s`: %v", policy.EntityOrHash(), err)
+				return
 			}
-			pe.sendNotice(ctx, "Matched in %s with recommendations %+v\n\n%s", dur, match.Recommendations(), strings.Join(eventStrings, "\n"))
-		} else {
-			pe.sendNotice(ctx, "No match in %s", dur.String())
+			actions = append(actions, policyActions...)
 		}
 	}
+	if len(actions) > 0 {
+		// Lists with auto-unban enabled were already handled by the policy removal above,
+		// so only force unbans for the other lists.
+		forcedMeta := *list
+		forcedMeta.AutoUnban = true
+		pe.reevaluateActions(ctx, actions, map[id.RoomID]*config.WatchedPolicyList{list.RoomID: &forcedMeta})
+	}
+	ce.React(ctx)
+}
+
+func (pe *PolicyEvaluator) cmdKick(ctx context.Context, ce *CommandEvent) {
+	userID := id.UserID(ce.Args[0])
+	rooms := pe.getRoomsUserIsIn(userID)
+	if len(rooms) == 0 {
+		ce.ReplyError(ctx, "[%s](%s) is not in any protected rooms", userID, userID.URI().MatrixToURL())
+		return
+	}
+	reason := strings.Join(ce.Args[1:], " ")
+	for _, roomID := range rooms {
+		pe.KickUser(ctx, userID, roomID, reason)
+	}
+	ce.React(ctx)
 }
 
-func (pe *PolicyEvaluator) SendPolicy(ctx context.Context, policyList id.RoomID, entityType policylist.EntityType, stateKey string, content *event.ModPolicyContent) (*mautrix.RespSendEvent, error) {
+var durationRegex = regexp.MustCompile(`^(\d+)([smhdw])$`)
+
+var errDurationTooLong = errors.New("duration is too long")
+
+// parseDuration parses a simple duration like `30m`, `12h`, `7d` or `2w`.
+// The bool is false if the input isn't a duration. An error is returned if it is, but it's too long.
+func parseDuration(input string) (time.Duration, bool, error) {
+	match := durationRegex.FindStringSubmatch(strings.ToLower(input))
+	if match == nil {
+		return 0, false, nil
+	}
+	value, err := strconv.ParseInt(match[1], 10, 64)
+	if errors.Is(err, strconv.ErrRange) {
+		return 0, true, errDurationTooLong
+	} else if err != nil || value <= 0 {
+		return 0, false, nil
+	}
+	unit := time.Second
+	switch match[2] {
+	case "m":
+		unit = time.Minute
+	case "h":
+		unit = time.Hour
+	case "d":
+		unit = 24 * time.Hour
+	case "w":
+		unit = 7 * 24 * time.Hour
+	}
+	if time.Duration(value) > math.MaxInt64/unit {
+		return 0, true, errDurationTooLong
+	}
+	return time.Duration(value) * unit, true, nil
+}
+
+// SendPolicy sends a policy event to the given policy list. If expiry is non-zero, the policy will expire
+// after the given duration.
+func (pe *PolicyEvaluator) SendPolicy(ctx context.Context, policyList id.RoomID, entityType policylist.EntityType, stateKey string, content *event.ModPolicyContent, expiry time.Duration) (*mautrix.RespSendEvent, error) {
 	if stateKey == "" {
 		stateKeyHash := sha256.Sum256(append([]byte(content.Entity), []byte(content.Recommendation)...))
 		stateKey = base64.StdEncoding.EncodeToString(stateKeyHash[:])
 	}
-	return pe.Bot.SendStateEvent(ctx, policyList, entityType.EventType(), stateKey, content)
+	wrappedContent := &event.Content{Parsed: content}
+	if expiry > 0 {
+		wrappedContent.Raw = map[string]any{
+			policylist.ExpiryKey: time.Now().Add(expiry).UnixMilli(),
+		}
+	}
+	return pe.Bot.SendStateEvent(ctx, policyList, entityType.EventType(), stateKey, wrappedContent)
 }
 
+// formatReportedObject returns a markdown description of what was reported, e.g. a link to the reported event.
+func formatReportedObject(report *database.Report) string {
+	switch report.Type() {
+	case database.ReportTypeRoom:
+		return fmt.Sprintf("the room [%s](%s)", report.RoomID, report.RoomID.URI().MatrixToURL())
+	case database.ReportTypeUser:
+		return fmt.Sprintf("[%s](%s)", report.TargetUser, report.TargetUser.URI().MatrixToURL())
+	default:
+		return fmt.Sprintf("[an event](%s)", report.RoomID.EventURI(report.EventID).MatrixToURL())
+	}
+}
+
+func formatReportNotice(report *database.Report) string {
+	switch {
+	case report.Type() == database.ReportTypeRoom:
+		return fmt.Sprintf(
+			"[%s](%s) reported the room [%s](%s) for %s (report `%s`)",
+			report.Reporter, report.Reporter.URI().MatrixToURL(), report.RoomID, report.RoomID.URI().MatrixToURL(),
+			report.Reason, report.ID,
+		)
+	case report.Type() == database.ReportTypeUser:
+		return fmt.Sprintf(
+			"[%s](%s) reported the user [%s](%s) for %s (report `%s`)",
+			report.Reporter, report.Reporter.URI().MatrixToURL(), report.TargetUser, report.TargetUser.URI().MatrixToURL(),
+			report.Reason, report.ID,
+		)
+	case report.TargetUser == "":
+		return fmt.Sprintf(
+			"[%s](%s) reported [an event](%s) for %s, but the event could not be fetched (report `%s`)",
+			report.Reporter, report.Reporter.URI().MatrixToURL(), report.RoomID.EventURI(report.EventID).MatrixToURL(),
+			report.Reason, report.ID,
+		)
+	default:
+		return fmt.Sprintf(
+			"[%s](%s) reported [an event](%s) from [%s](%s) for %s (report `%s`)",
+			report.Reporter, report.Reporter.URI().MatrixToURL(), report.RoomID.EventURI(report.EventID).MatrixToURL(),
+			report.TargetUser, report.TargetUser.URI().MatrixToURL(), report.Reason, report.ID,
+		)
+	}
+}
+
+func (pe *PolicyEvaluator) newReport(sender, target id.UserID, roomID id.RoomID, eventID id.EventID, reason string) *database.Report {
+	return &database.Report{
+		ID:             random.String(16),
+		ManagementRoom: pe.ManagementRoom,
+		Reporter:       sender,
+		TargetUser:     target,
+		RoomID:         roomID,
+		EventID:        eventID,
+		Reason:         reason,
+		CreatedAt:      time.Now(),
+		Status:         database.ReportStatusOpen,
+	}
+}
+
+// saveReport sends a notice about a received report to the management room and stores the report in the database,
+// so that it can be triaged later. If saving fails, the error is logged.
+func (pe *PolicyEvaluator) saveReport(ctx context.Context, report *database.Report, fetchErr error) {
+	message := formatReportNotice(report)
+	if fetchErr != nil {
+		message += fmt.Sprintf("\n\nFailed to fetch the event: %v", fetchErr)
+	}
+	report.NoticeEventID = pe.Bot.SendNoticeOpts(ctx, pe.ManagementRoom, message, nil)
+	err := pe.DB.Report.Insert(ctx, report)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to save report to database")
+	}
+}
+
+// checkCanBan returns an error if the entity already has a ban or unban recommendation in the applied lists.
+// Entities that are banned can still be taken down.
+func (pe *PolicyEvaluator) checkCanBan(entityType policylist.EntityType, entity string, recommendation event.PolicyRecommendation) error {
+	var match policylist.Match
+	if entityType == policylist.EntityTypeRoom {
+		match = pe.Store.MatchRoom(pe.GetWatchedLists(), id.RoomID(entity))
+	} else {
+		match = pe.Store.MatchUser(pe.GetWatchedLists(), id.UserID(entity))
+	}
+	rec := match.Recommendations().BanOrUnban
+	if rec == nil {
+		return nil
+	} else if rec.Recommendation == event.PolicyRecommendationUnban {
+		return mautrix.RespError{
+			ErrCode:    "FI.MAU.MEOWLNIR.UNBAN_RECOMMENDED",
+			Err:        fmt.Sprintf("%s has an unban recommendation: %s", entity, rec.Reason),
+			StatusCode: http.StatusConflict,
+		}
+	} else if recommendation == policylist.PolicyRecommendationTakedown {
+		if rec.Recommendation != policylist.PolicyRecommendationTakedown {
+			return nil
+		}
+		return mautrix.RespError{
+			ErrCode:    "FI.MAU.MEOWLNIR.ALREADY_TAKEN_DOWN",
+			Err:        fmt.Sprintf("%s is already taken down for: %s", entity, rec.Reason),
+			StatusCode: http.StatusConflict,
+		}
+	} else {
+		return mautrix.RespError{
+			ErrCode:    "FI.MAU.MEOWLNIR.ALREADY_BANNED",
+			Err:        fmt.Sprintf("%s is already banned for: %s", entity, rec.Reason),
+			StatusCode: http.StatusConflict,
+		}
+	}
+}
+
+// isReportCommand checks if the report is an admin using the reason field to run a slash command.
+func (pe *PolicyEvaluator) isReportCommand(report *database.Report) bool {
+	return pe.Admins.Has(report.Reporter) && strings.HasPrefix(report.Reason, "/")
+}
+
+// HandleReport handles a report of an event.
 func (pe *PolicyEvaluator) HandleReport(ctx context.Context, sender id.UserID, roomID id.RoomID, eventID id.EventID, reason string) error {
 	evt, err := pe.Bot.Client.GetEvent(ctx, roomID, eventID)
 	if err != nil {
@@ -112,72 +378,35 @@ func (pe *PolicyEvaluator) HandleReport(ctx context.Context, sender id.UserID, r
 				Err(err).
 				AnErr("db_error", synErr).
 				Msg("Failed to get report target event from both API and database")
-			pe.sendNotice(
-				ctx, `[%s](%s) reported [an event](%s) for %s, but the event could not be fetched: %v`,
-				sender, sender.URI().MatrixToURL(), roomID.EventURI(eventID).MatrixToURL(), reason, err,
-			)
+			pe.saveReport(ctx, pe.newReport(sender, "", roomID, eventID, reason), err)
 			return fmt.Errorf("failed to fetch event: %w", err)
 		}
 	}
-	if !pe.Admins.Has(sender) || !strings.HasPrefix(reason, "/") {
-		pe.sendNotice(
-			ctx, `[%s](%s) reported [an event](%s) from [%s](%s) for %s`,
-			sender, sender.URI().MatrixToURL(), roomID.EventURI(eventID).MatrixToURL(),
-			evt.Sender, evt.Sender.URI().MatrixToURL(),
-			reason,
-		)
+	report := pe.newReport(sender, evt.Sender, roomID, eventID, reason)
+	if !pe.isReportCommand(report) {
+		pe.saveReport(ctx, report, nil)
+		pe.checkReportThreshold(ctx, evt.Sender)
 		return nil
 	}
-	fields := strings.Fields(reason)
-	cmd := strings.TrimPrefix(fields[0], "/")
-	args := fields[1:]
-	switch strings.ToLower(cmd) {
-	case "ban":
-		if len(args) < 2 {
-			return mautrix.MInvalidParam.WithMessage("Not enough arguments for ban")
-		}
-		match := pe.Store.MatchUser(pe.GetWatchedLists(), evt.Sender)
-		if rec := match.Recommendations().BanOrUnban; rec != nil {
-			if rec.Recommendation == event.PolicyRecommendationUnban {
-				return mautrix.RespError{
-					ErrCode:    "FI.MAU.MEOWLNIR.UNBAN_RECOMMENDED",
-					Err:        fmt.Sprintf("%s has an unban recommendation: %s", evt.Sender, rec.Reason),
-					StatusCode: http.StatusConflict,
-				}
-			} else {
-				return mautrix.RespError{
-					ErrCode:    "FI.MAU.MEOWLNIR.ALREADY_BANNED",
-					Err:        fmt.Sprintf("%s is already banned for: %s", evt.Sender, rec.Reason),
-					StatusCode: http.StatusConflict,
-				}
-			}
-		}
-		list := pe.FindListByShortcode(args[0])
-		if list == nil {
-			pe.sendNotice(ctx, `Failed to handle [%s](%s)'s report of [%s](%s): list %q not found`,
-				sender, sender.URI().MatrixToURL(), evt.Sender, evt.Sender.URI().MatrixToURL(), args[0])
-			return mautrix.MNotFound.WithMessage(fmt.Sprintf("List with shortcode %q not found", args[0]))
-		}
-		policy := &event.ModPolicyContent{
-			Entity:         string(evt.Sender),
-			Reason:         strings.Join(args[1:], " "),
-			Recommendation: event.PolicyRecommendationBan,
-		}
-		resp, err := pe.SendPolicy(ctx, list.RoomID, policylist.EntityTypeUser, "", policy)
-		if err != nil {
-			pe.sendNotice(ctx, `Failed to handle [%s](%s)'s report of [%s](%s) for %s ([%s](%s)): %v`,
-				sender, sender.URI().MatrixToURL(), evt.Sender, evt.Sender.URI().MatrixToURL(),
-				list.Name, list.RoomID, list.RoomID.URI().MatrixToURL(), err)
-			return fmt.Errorf("failed to send policy: %w", err)
-		}
-		zerolog.Ctx(ctx).Info().
-			Stringer("policy_list", list.RoomID).
-			Any("policy", policy).
-			Stringer("policy_event_id", resp.EventID).
-			Msg("Sent ban policy from report")
-		pe.sendNotice(ctx, `Processed [%s](%s)'s report of [%s](%s) and sent a ban policy to %s ([%s](%s)) for %s`,
-			sender, sender.URI().MatrixToURL(), evt.Sender, evt.Sender.URI().MatrixToURL(),
-			list.Name, list.RoomID, list.RoomID.URI().MatrixToURL(), policy.Reason)
+	return pe.handleReportCommand(ctx, report)
+}
+
+// HandleRoomReport handles a report of an entire room (MSC4151).
+func (pe *PolicyEvaluator) HandleRoomReport(ctx context.Context, sender id.UserID, roomID id.RoomID, reason string) error {
+	report := pe.newReport(sender, "", roomID, "", reason)
+	if !pe.isReportCommand(report) {
+		pe.saveReport(ctx, report, nil)
+		return nil
+	}
+	return pe.handleReportCommand(ctx, report)
+}
+
+// HandleUserReport handles a report of a user.
+func (pe *PolicyEvaluator) HandleUserReport(ctx context.Context, sender, userID id.UserID, reason string) error {
+	report := pe.newReport(sender, userID, "", "", reason)
+	if !pe.isReportCommand(report) {
+		pe.saveReport(ctx, report, nil)
+		return nil
 	}
-	return nil
+	return pe.handleReportCommand(ctx, report)
 }
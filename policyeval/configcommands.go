@@ -0,0 +1,261 @@
+package policyeval
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/meowlnir/config"
+)
+
+func init() {
+	registerCommands(
+		&CommandHandler{
+			Name:        "protect",
+			Usage:       "<room ID or alias>",
+			Description: "Join the room and add it to the protected rooms list.",
+			MinArgs:     1,
+			Func:        (*PolicyEvaluator).cmdProtect,
+		},
+		&CommandHandler{
+			Name:        "unprotect",
+			Usage:       "<room ID or alias>",
+			Description: "Remove the room from the protected rooms list.",
+			MinArgs:     1,
+			Func:        (*PolicyEvaluator).cmdUnprotect,
+		},
+		&CommandHandler{
+			Name:        "watch",
+			Usage:       "<room ID or alias> <shortcode> [--dont-apply] [--auto-unban]",
+			Description: "Join the policy list and subscribe to it.",
+			MinArgs:     2,
+			Flags:       []string{"dont-apply", "auto-unban"},
+			Func:        (*PolicyEvaluator).cmdWatch,
+		},
+		&CommandHandler{
+			Name:        "unwatch",
+			Usage:       "<room ID, alias or shortcode>",
+			Description: "Unsubscribe from the policy list.",
+			MinArgs:     1,
+			Func:        (*PolicyEvaluator).cmdUnwatch,
+		},
+	)
+}
+
+// resolveRoom resolves the given room ID or alias into a room ID.
+func (pe *PolicyEvaluator) resolveRoom(ctx context.Context, roomIDOrAlias string) (id.RoomID, error) {
+	if strings.HasPrefix(roomIDOrAlias, "#") {
+		resp, err := pe.Bot.ResolveAlias(ctx, id.RoomAlias(roomIDOrAlias))
+		if err != nil {
+			return "", fmt.Errorf("failed to resolve alias: %w", err)
+		}
+		return resp.RoomID, nil
+	} else if strings.HasPrefix(roomIDOrAlias, "!") {
+		return id.RoomID(roomIDOrAlias), nil
+	}
+	return "", fmt.Errorf("%q is not a room ID or alias", roomIDOrAlias)
+}
+
+// managementList is a list in a config state event of the management room, like the protected rooms
+// or watched lists. The event is edited as raw JSON, so that fields which this version doesn't know about
+// are preserved, both in the event content and in the list items.
+type managementList[T any] struct {
+	evtType event.Type
+	key     string
+	content map[string]json.RawMessage
+	raw     []json.RawMessage
+	Items   []T
+}
+
+// getManagementList fetches the given config state event from the management room and parses the list in it.
+// If the event doesn't exist, the list is empty.
+func getManagementList[T any](ctx context.Context, pe *PolicyEvaluator, evtType event.Type, key string) (*managementList[T], error) {
+	ml := &managementList[T]{evtType: evtType, key: key}
+	err := pe.Bot.StateEvent(ctx, pe.ManagementRoom, evtType, "", &ml.content)
+	if errors.Is(err, mautrix.MNotFound) {
+		ml.content = make(map[string]json.RawMessage)
+	} else if err != nil {
+		return nil, err
+	} else if ml.content == nil {
+		ml.content = make(map[string]json.RawMessage)
+	}
+	if rawList, ok := ml.content[key]; ok {
+		if err = json.Unmarshal(rawList, &ml.raw); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", key, err)
+		}
+	}
+	ml.Items = make([]T, len(ml.raw))
+	for i, item := range ml.raw {
+		if err = json.Unmarshal(item, &ml.Items[i]); err != nil {
+			return nil, fmt.Errorf("failed to parse item #%d in %s: %w", i+1, key, err)
+		}
+	}
+	return ml, nil
+}
+
+func (ml *managementList[T]) Append(item T) error {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	ml.raw = append(ml.raw, data)
+	ml.Items = append(ml.Items, item)
+	return nil
+}
+
+func (ml *managementList[T]) Delete(idx int) {
+	ml.raw = slices.Delete(ml.raw, idx, idx+1)
+	ml.Items = slices.Delete(ml.Items, idx, idx+1)
+}
+
+// Save sends the edited list back to the management room.
+func (ml *managementList[T]) Save(ctx context.Context, pe *PolicyEvaluator) error {
+	data, err := json.Marshal(ml.raw)
+	if err != nil {
+		return err
+	}
+	ml.content[ml.key] = data
+	_, err = pe.Bot.SendStateEvent(ctx, pe.ManagementRoom, ml.evtType, "", ml.content)
+	return err
+}
+
+func (pe *PolicyEvaluator) cmdProtect(ctx context.Context, ce *CommandEvent) {
+	pe.configEditLock.Lock()
+	defer pe.configEditLock.Unlock()
+	resp, err := pe.Bot.JoinRoom(ctx, ce.Args[0], "", nil)
+	if err != nil {
+		ce.ReplyError(ctx, "Failed to join room %q: %v", ce.Args[0], err)
+		return
+	}
+	rooms, err := getManagementList[id.RoomID](ctx, pe, config.StateProtectedRooms, "rooms")
+	if err != nil {
+		ce.ReplyError(ctx, "Failed to get current protected rooms: %v", err)
+		return
+	} else if slices.Contains(rooms.Items, resp.RoomID) {
+		ce.ReplyError(ctx, "[%s](%s) is already protected", resp.RoomID, resp.RoomID.URI().MatrixToURL())
+		return
+	}
+	if err = rooms.Append(resp.RoomID); err == nil {
+		err = rooms.Save(ctx, pe)
+	}
+	if err != nil {
+		ce.ReplyError(ctx, "Failed to update protected rooms: %v", err)
+		return
+	}
+	ce.React(ctx)
+}
+
+func (pe *PolicyEvaluator) cmdUnprotect(ctx context.Context, ce *CommandEvent) {
+	pe.configEditLock.Lock()
+	defer pe.configEditLock.Unlock()
+	roomID, err := pe.resolveRoom(ctx, ce.Args[0])
+	if err != nil {
+		ce.ReplyError(ctx, "Failed to find room: %v", err)
+		return
+	}
+	rooms, err := getManagementList[id.RoomID](ctx, pe, config.StateProtectedRooms, "rooms")
+	if err != nil {
+		ce.ReplyError(ctx, "Failed to get current protected rooms: %v", err)
+		return
+	}
+	idx := slices.Index(rooms.Items, roomID)
+	if idx < 0 {
+		ce.ReplyError(ctx, "[%s](%s) is not protected", roomID, roomID.URI().MatrixToURL())
+		return
+	}
+	rooms.Delete(idx)
+	err = rooms.Save(ctx, pe)
+	if err != nil {
+		ce.ReplyError(ctx, "Failed to update protected rooms: %v", err)
+		return
+	}
+	ce.React(ctx)
+}
+
+func (pe *PolicyEvaluator) cmdWatch(ctx context.Context, ce *CommandEvent) {
+	pe.configEditLock.Lock()
+	defer pe.configEditLock.Unlock()
+	shortcode := ce.Args[1]
+	lists, err := getManagementList[config.WatchedPolicyList](ctx, pe, config.StateWatchedLists, "lists")
+	if err != nil {
+		ce.ReplyError(ctx, "Failed to get current watched lists: %v", err)
+		return
+	} else if slices.ContainsFunc(lists.Items, func(list config.WatchedPolicyList) bool {
+		return strings.EqualFold(list.Shortcode, shortcode)
+	}) {
+		ce.ReplyError(ctx, "There's already a list with the shortcode `%s`", shortcode)
+		return
+	}
+	resp, err := pe.Bot.JoinRoom(ctx, ce.Args[0], "", nil)
+	if err != nil {
+		ce.ReplyError(ctx, "Failed to join room %q: %v", ce.Args[0], err)
+		return
+	} else if slices.ContainsFunc(lists.Items, func(list config.WatchedPolicyList) bool {
+		return list.RoomID == resp.RoomID
+	}) {
+		ce.ReplyError(ctx, "[%s](%s) is already watched", resp.RoomID, resp.RoomID.URI().MatrixToURL())
+		return
+	}
+	name := shortcode
+	var nameContent event.RoomNameEventContent
+	if err = pe.Bot.StateEvent(ctx, resp.RoomID, event.StateRoomName, "", &nameContent); err == nil && nameContent.Name != "" {
+		name = nameContent.Name
+	}
+	err = lists.Append(config.WatchedPolicyList{
+		RoomID:    resp.RoomID,
+		Name:      name,
+		Shortcode: shortcode,
+		DontApply: ce.Flags["dont-apply"],
+		AutoUnban: ce.Flags["auto-unban"],
+	})
+	if err == nil {
+		err = lists.Save(ctx, pe)
+	}
+	if err != nil {
+		ce.ReplyError(ctx, "Failed to update watched lists: %v", err)
+		return
+	}
+	ce.React(ctx)
+}
+
+func (pe *PolicyEvaluator) cmdUnwatch(ctx context.Context, ce *CommandEvent) {
+	pe.configEditLock.Lock()
+	defer pe.configEditLock.Unlock()
+	var roomID id.RoomID
+	if list := pe.FindListByShortcode(ce.Args[0]); list != nil {
+		roomID = list.RoomID
+	} else {
+		var err error
+		roomID, err = pe.resolveRoom(ctx, ce.Args[0])
+		if err != nil {
+			ce.ReplyError(ctx, "Failed to find list: %v", err)
+			return
+		}
+	}
+	lists, err := getManagementList[config.WatchedPolicyList](ctx, pe, config.StateWatchedLists, "lists")
+	if err != nil {
+		ce.ReplyError(ctx, "Failed to get current watched lists: %v", err)
+		return
+	}
+	idx := slices.IndexFunc(lists.Items, func(list config.WatchedPolicyList) bool {
+		return list.RoomID == roomID
+	})
+	if idx < 0 {
+		ce.ReplyError(ctx, "[%s](%s) is not watched", roomID, roomID.URI().MatrixToURL())
+		return
+	}
+	lists.Delete(idx)
+	err = lists.Save(ctx, pe)
+	if err != nil {
+		ce.ReplyError(ctx, "Failed to update watched lists: %v", err)
+		return
+	}
+	ce.React(ctx)
+}
@@ -2,13 +2,16 @@ package policyeval
 
 import (
 	"context"
+	"fmt"
 	"maps"
 	"slices"
 
 	"github.com/rs/zerolog"
+	"go.mau.fi/util/glob"
 	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/id"
 
+	"go.mau.fi/meowlnir/config"
 	"go.mau.fi/meowlnir/database"
 	"go.mau.fi/meowlnir/policylist"
 )
@@ -27,13 +30,34 @@ func (pe *PolicyEvaluator) EvaluateAllMembers(ctx context.Context, members []id.
 }
 
 func (pe *PolicyEvaluator) EvaluateUser(ctx context.Context, userID id.UserID, isNewRule bool) {
-	match := pe.Store.MatchUser(pe.GetWatchedLists(), userID)
+	match := pe.matchUser(userID)
 	if match == nil {
 		return
 	}
 	pe.ApplyPolicy(ctx, userID, match, isNewRule)
 }
 
+// matchUser finds all user rules matching the given user ID and all server rules matching the user's server.
+//
+// User rules come first in the output, so they take priority over server rules (except for takedowns).
+func (pe *PolicyEvaluator) matchUser(userID id.UserID) policylist.Match {
+	return pe.matchEntity(policylist.EntityTypeUser, string(userID), pe.GetWatchedLists())
+}
+
+// policyAppliesToUser checks if the given user or server policy matches the given user.
+func (pe *PolicyEvaluator) policyAppliesToUser(policy *policylist.Policy, userID id.UserID) bool {
+	switch policy.EntityType {
+	case policylist.EntityTypeUser:
+		return policy.Pattern.Match(string(userID))
+	case policylist.EntityTypeServer:
+		server := userID.Homeserver()
+		// Server rules are never applied to users on our own server, same as with server ACLs
+		return server != pe.Bot.UserID.Homeserver() && policy.Pattern.Match(server)
+	default:
+		return false
+	}
+}
+
 func (pe *PolicyEvaluator) EvaluateRemovedRule(ctx context.Context, policy *policylist.Policy) {
 	if policy.Recommendation == event.PolicyRecommendationUnban {
 		// When an unban rule is removed, evaluate all joined users against the removed rule
@@ -42,15 +66,15 @@ func (pe *PolicyEvaluator) EvaluateRemovedRule(ctx context.Context, policy *poli
 		users := slices.Collect(maps.Keys(pe.protectedRoomMembers))
 		pe.protectedRoomsLock.RUnlock()
 		for _, userID := range users {
-			if policy.Pattern.Match(string(userID)) {
+			if pe.policyAppliesToUser(policy, userID) {
 				pe.EvaluateUser(ctx, userID, false)
 			}
 		}
 	} else {
 		// For ban rules, find users who were banned by the rule and re-evaluate them.
-		reevalTargets, err := pe.DB.TakenAction.GetAllByRuleEntity(ctx, policy.RoomID, policy.Entity)
+		reevalTargets, err := pe.DB.TakenAction.GetAllByRuleEntity(ctx, policy.RoomID, policy.EntityOrHash())
 		if err != nil {
-			zerolog.Ctx(ctx).Err(err).Str("policy_entity", policy.Entity).
+			zerolog.Ctx(ctx).Err(err).Str("policy_entity", policy.EntityOrHash()).
 				Msg("Failed to get actions taken for removed policy")
 			pe.sendNotice(ctx, "Database error in EvaluateRemovedRule (GetAllByRuleEntity): %v", err)
 			return
@@ -60,23 +84,61 @@ func (pe *PolicyEvaluator) EvaluateRemovedRule(ctx context.Context, policy *poli
 }
 
 func (pe *PolicyEvaluator) EvaluateAddedRule(ctx context.Context, policy *policylist.Policy) {
+	if policy.EntityType == policylist.EntityTypeRoom {
+		pe.EvaluateAddedRoomRule(ctx, policy)
+		return
+	}
 	pe.protectedRoomsLock.RLock()
 	users := slices.Collect(maps.Keys(pe.protectedRoomMembers))
 	pe.protectedRoomsLock.RUnlock()
+	if _, isExact := policy.Pattern.(glob.ExactGlob); isExact && policy.EntityType == policylist.EntityTypeUser &&
+		(policy.Recommendation == event.PolicyRecommendationUnban || policy.Recommendation == policylist.PolicyRecommendationTakedown) &&
+		!slices.Contains(users, id.UserID(policy.Entity)) {
+		// Banned users may not be in the member map, so make sure exact unban rules are always evaluated.
+		// The same applies to takedowns, which must redact the user's events even if they're already banned.
+		users = append(users, id.UserID(policy.Entity))
+	}
+	if policy.Recommendation == event.PolicyRecommendationUnban {
+		users = pe.appendBannedUsers(ctx, users)
+	}
 	for _, userID := range users {
-		if policy.Pattern.Match(string(userID)) {
+		if pe.policyAppliesToUser(policy, userID) {
 			// Do a full evaluation to ensure new policies don't bypass existing higher priority policies
 			pe.EvaluateUser(ctx, userID, true)
 		}
 	}
 }
 
-func (pe *PolicyEvaluator) ReevaluateAffectedByLists(ctx context.Context, policyLists []id.RoomID) {
+// appendBannedUsers adds users who have been banned by Meowlnir to the given list. Banned users aren't in the
+// member map, so they have to be evaluated separately for unban rules, including glob rules.
+func (pe *PolicyEvaluator) appendBannedUsers(ctx context.Context, users []id.UserID) []id.UserID {
+	actions, err := pe.DB.TakenAction.GetAllByType(ctx, database.TakenActionTypeBanOrUnban)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to get banned users to evaluate unban rule")
+		pe.sendNotice(ctx, "Database error in EvaluateAddedRule (GetAllByType): %v", err)
+		return users
+	}
+	seen := make(map[id.UserID]struct{}, len(users))
+	for _, userID := range users {
+		seen[userID] = struct{}{}
+	}
+	for _, action := range actions {
+		if _, alreadyAdded := seen[action.TargetUser]; !alreadyAdded && policylist.IsBanRecommendation(action.Action) {
+			seen[action.TargetUser] = struct{}{}
+			users = append(users, action.TargetUser)
+		}
+	}
+	return users
+}
+
+func (pe *PolicyEvaluator) ReevaluateAffectedByLists(ctx context.Context, policyLists []*config.WatchedPolicyList) {
 	var reevalTargets []*database.TakenAction
+	listMeta := make(map[id.RoomID]*config.WatchedPolicyList, len(policyLists))
 	for _, list := range policyLists {
-		targets, err := pe.DB.TakenAction.GetAllByPolicyList(ctx, list)
+		listMeta[list.RoomID] = list
+		targets, err := pe.DB.TakenAction.GetAllByPolicyList(ctx, list.RoomID)
 		if err != nil {
-			zerolog.Ctx(ctx).Err(err).Stringer("policy_list_id", list).
+			zerolog.Ctx(ctx).Err(err).Stringer("policy_list_id", list.RoomID).
 				Msg("Failed to get actions taken from policy list")
 			pe.sendNotice(ctx, "Database error in ReevaluateAffectedByLists (GetAllByPolicyList): %v", err)
 			continue
@@ -87,9 +149,52 @@ func (pe *PolicyEvaluator) ReevaluateAffectedByLists(ctx context.Context, policy
 			reevalTargets = append(reevalTargets, targets...)
 		}
 	}
-	pe.ReevaluateActions(ctx, reevalTargets)
+	pe.reevaluateActions(ctx, reevalTargets, listMeta)
 }
 
+// ReevaluateActions checks whether the given previously taken actions are still valid,
+// and reverts them if the policy list that caused them has auto-unban enabled.
 func (pe *PolicyEvaluator) ReevaluateActions(ctx context.Context, actions []*database.TakenAction) {
+	pe.reevaluateActions(ctx, actions, nil)
+}
 
+func (pe *PolicyEvaluator) reevaluateActions(ctx context.Context, actions []*database.TakenAction, listMeta map[id.RoomID]*config.WatchedPolicyList) {
+	for _, action := range actions {
+		if action.ActionType == database.TakenActionTypeRoomBlock {
+			pe.reevaluateRoomBlock(ctx, action, listMeta)
+			continue
+		} else if action.ActionType != database.TakenActionTypeBanOrUnban || !policylist.IsBanRecommendation(action.Action) {
+			continue
+		}
+		log := zerolog.Ctx(ctx).With().Any("taken_action", action).Logger()
+		rec := pe.matchUser(action.TargetUser).Recommendations().BanOrUnban
+		if rec != nil && rec.Recommendation != event.PolicyRecommendationUnban {
+			if rec.RoomID != action.PolicyList || rec.EntityOrHash() != action.RuleEntity {
+				// The user is still banned by another rule, update the taken action to point at that rule,
+				// so that it'll be re-evaluated if the new rule is removed too.
+				action.PolicyList = rec.RoomID
+				action.RuleEntity = rec.EntityOrHash()
+				action.RuleType = string(rec.EntityType)
+				err := pe.DB.TakenAction.Put(ctx, action)
+				if err != nil {
+					log.Err(err).Msg("Failed to update taken action with new rule")
+				}
+			}
+			log.Debug().Str("new_rule_entity", rec.EntityOrHash()).Msg("Ban is still valid after re-evaluation")
+			continue
+		}
+		meta := listMeta[action.PolicyList]
+		if meta == nil {
+			meta = pe.GetWatchedListMeta(action.PolicyList)
+		}
+		if meta == nil || !meta.AutoUnban {
+			log.Debug().Msg("Ban is no longer valid, but policy list doesn't have auto-unban enabled")
+			continue
+		}
+		reason := fmt.Sprintf("ban policy for %s was removed from or expired in %s", action.RuleEntity, meta.Name)
+		if rec != nil {
+			reason = fmt.Sprintf("unban recommended: %s", rec.Reason)
+		}
+		pe.ApplyUnban(ctx, action, reason)
+	}
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/rs/zerolog"
 	"maunium.net/go/mautrix/event"
@@ -76,6 +77,8 @@ func addActionString(rec event.PolicyRecommendation) string {
 	switch rec {
 	case event.PolicyRecommendationBan:
 		return "banned"
+	case policylist.PolicyRecommendationTakedown:
+		return "took down"
 	case event.PolicyRecommendationUnban:
 		return "added a ban exclusion for"
 	default:
@@ -87,6 +90,8 @@ func changeActionString(rec event.PolicyRecommendation) string {
 	switch rec {
 	case event.PolicyRecommendationBan:
 		return "ban"
+	case policylist.PolicyRecommendationTakedown:
+		return "takedown"
 	case event.PolicyRecommendationUnban:
 		return "ban exclusion"
 	default:
@@ -98,6 +103,8 @@ func removeActionString(rec event.PolicyRecommendation) string {
 	switch rec {
 	case event.PolicyRecommendationBan:
 		return "unbanned"
+	case policylist.PolicyRecommendationTakedown:
+		return "removed a takedown of"
 	case event.PolicyRecommendationUnban:
 		return "removed a ban exclusion for"
 	default:
@@ -115,25 +122,39 @@ func (pe *PolicyEvaluator) HandlePolicyListChange(ctx context.Context, policyRoo
 		Any("added", added).
 		Any("removed", removed).
 		Msg("Policy list change")
-	removedAndAddedAreEquivalent := removed != nil && added != nil && removed.Entity == added.Entity && removed.Recommendation == added.Recommendation
+	removedAndAddedAreEquivalent := removed != nil && added != nil && removed.EntityOrHash() == added.EntityOrHash() && removed.Recommendation == added.Recommendation
+	if !policyRoomMeta.DontApply && !removedAndAddedAreEquivalent &&
+		((added != nil && added.EntityType == policylist.EntityTypeServer) ||
+			(removed != nil && removed.EntityType == policylist.EntityTypeServer)) {
+		pe.DeferredUpdateACL()
+	}
 	if removedAndAddedAreEquivalent {
 		if removed.Reason == added.Reason {
 			pe.sendNotice(ctx,
 				"[%s] [%s](%s) re-%s `%s` for `%s`",
 				policyRoomMeta.Name, added.Sender, added.Sender.URI().MatrixToURL(),
-				addActionString(added.Recommendation), added.Entity, added.Reason)
+				addActionString(added.Recommendation), added.EntityOrHash(), added.Reason)
 		} else {
 			pe.sendNotice(ctx,
 				"[%s] [%s](%s) changed the %s reason for `%s` from `%s` to `%s`",
 				policyRoomMeta.Name, added.Sender, added.Sender.URI().MatrixToURL(),
-				changeActionString(added.Recommendation), added.Entity, removed.Reason, added.Reason)
+				changeActionString(added.Recommendation), added.EntityOrHash(), removed.Reason, added.Reason)
 		}
 	} else {
-		if removed != nil {
+		if removed != nil && added == nil && removed.IsExpired(time.Now()) {
+			pe.sendNotice(ctx,
+				"[%s] %s rule by [%s](%s) for %ss matching `%s` expired (reason: %s)",
+				policyRoomMeta.Name, changeActionString(removed.Recommendation), removed.Sender, removed.Sender.URI().MatrixToURL(),
+				removed.EntityType, removed.EntityOrHash(), removed.Reason,
+			)
+			if !policyRoomMeta.DontApply {
+				pe.EvaluateRemovedRule(ctx, removed)
+			}
+		} else if removed != nil {
 			pe.sendNotice(ctx,
 				"[%s] [%s](%s) %s %ss matching `%s` for %s",
 				policyRoomMeta.Name, removed.Sender, removed.Sender.URI().MatrixToURL(),
-				removeActionString(removed.Recommendation), removed.EntityType, removed.Entity, removed.Reason,
+				removeActionString(removed.Recommendation), removed.EntityType, removed.EntityOrHash(), removed.Reason,
 			)
 			if !policyRoomMeta.DontApply {
 				pe.EvaluateRemovedRule(ctx, removed)
@@ -143,11 +164,13 @@ func (pe *PolicyEvaluator) HandlePolicyListChange(ctx context.Context, policyRoo
 			var suffix string
 			if added.Ignored {
 				suffix = " (rule was ignored)"
+			} else if added.Expiry != 0 {
+				suffix = fmt.Sprintf(" (expires at %s)", time.UnixMilli(added.Expiry).Format(time.RFC3339))
 			}
 			pe.sendNotice(ctx,
 				"[%s] [%s](%s) %s %ss matching `%s` for %s%s",
 				policyRoomMeta.Name, added.Sender, added.Sender.URI().MatrixToURL(),
-				addActionString(added.Recommendation), added.EntityType, added.Entity, added.Reason,
+				addActionString(added.Recommendation), added.EntityType, added.EntityOrHash(), added.Reason,
 				suffix,
 			)
 			if !policyRoomMeta.DontApply {
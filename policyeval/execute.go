@@ -2,17 +2,17 @@ package policyeval
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/rs/zerolog"
-	"maunium.net/go/mautrix"
 	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/id"
 
+	"go.mau.fi/meowlnir/config"
 	"go.mau.fi/meowlnir/database"
 	"go.mau.fi/meowlnir/policylist"
 )
@@ -42,63 +42,183 @@ func (pe *PolicyEvaluator) ApplyPolicy(ctx context.Context, userID id.UserID, po
 		return
 	}
 	if recs.BanOrUnban != nil {
-		if recs.BanOrUnban.Recommendation == event.PolicyRecommendationBan {
+		if policylist.IsBanRecommendation(recs.BanOrUnban.Recommendation) {
 			zerolog.Ctx(ctx).Info().
 				Stringer("user_id", userID).
 				Any("matches", policy).
+				Str("recommendation", string(recs.BanOrUnban.Recommendation)).
 				Msg("Applying ban recommendation")
 			for _, room := range rooms {
 				pe.ApplyBan(ctx, userID, room, recs.BanOrUnban)
 			}
-			if recs.BanOrUnban.Reason == "spam" {
-				go pe.RedactUser(context.WithoutCancel(ctx), userID, recs.BanOrUnban.Reason, true)
+			pe.redactAfterBan(ctx, userID, recs.BanOrUnban)
+		} else if isNew {
+			pe.ApplyUnbanRecommendation(ctx, userID, recs.BanOrUnban)
+		}
+	}
+}
+
+// recentRedactionAge is how far back the "recent" redaction action looks for events to redact.
+const recentRedactionAge = 24 * time.Hour
+
+// redactAfterBan redacts the events of a banned user according to the redaction rules of the list the policy came from.
+// Takedowns always redact everything.
+func (pe *PolicyEvaluator) redactAfterBan(ctx context.Context, userID id.UserID, policy *policylist.Policy) {
+	action := config.RedactActionAll
+	if policy.Recommendation != policylist.PolicyRecommendationTakedown {
+		meta := pe.GetWatchedListMeta(policy.RoomID)
+		if meta == nil {
+			return
+		}
+		action = meta.RedactActionFor(policy.Reason)
+	}
+	var since time.Time
+	switch action {
+	case config.RedactActionAll:
+	case config.RedactActionRecent:
+		since = time.Now().Add(-recentRedactionAge)
+	default:
+		return
+	}
+	zerolog.Ctx(ctx).Debug().
+		Stringer("user_id", userID).
+		Str("redact_action", string(action)).
+		Msg("Redacting events of banned user")
+	go pe.redactUserSince(context.WithoutCancel(ctx), userID, policy.Reason, since, true)
+}
+
+// ApplyUnbanRecommendation lifts bans that Meowlnir has placed on the given user in protected rooms.
+// Bans that were placed manually by room moderators are left alone.
+func (pe *PolicyEvaluator) ApplyUnbanRecommendation(ctx context.Context, userID id.UserID, policy *policylist.Policy) {
+	takenActions, err := pe.DB.TakenAction.GetAllByTargetUser(ctx, userID, database.TakenActionTypeBanOrUnban)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Stringer("user_id", userID).Msg("Failed to get taken actions")
+		pe.sendNotice(ctx, "Database error in ApplyUnbanRecommendation (GetAllByTargetUser): %v", err)
+		return
+	}
+	bannedByUs := make(map[id.RoomID]*database.TakenAction, len(takenActions))
+	for _, ta := range takenActions {
+		if policylist.IsBanRecommendation(ta.Action) {
+			bannedByUs[ta.InRoomID] = ta
+		}
+	}
+	reason := fmt.Sprintf("unban recommended: %s", policy.Reason)
+	var unbannedCount int
+	var manualBans []string
+	for _, roomID := range pe.GetProtectedRooms() {
+		if ta, ok := bannedByUs[roomID]; ok {
+			if pe.ApplyUnban(ctx, ta, reason) {
+				unbannedCount++
 			}
-		} else {
-			// TODO unban if banned in some rooms? or just require doing that manually
-			//takenActions, err := pe.DB.TakenAction.GetAllByTargetUser(ctx, userID, database.TakenActionTypeBanOrUnban)
-			//if err != nil {
-			//	zerolog.Ctx(ctx).Err(err).Stringer("user_id", userID).Msg("Failed to get taken actions")
-			//	pe.sendNotice(ctx, "Database error in ApplyPolicy (GetAllByTargetUser): %v", err)
-			//	return
-			//}
+			continue
+		}
+		// Use the state store rather than fetching the member event, as this is called for every user matching the rule
+		member, err := pe.Bot.StateStore.TryGetMember(ctx, roomID, userID)
+		if err != nil {
+			zerolog.Ctx(ctx).Err(err).Stringer("user_id", userID).Stringer("room_id", roomID).
+				Msg("Failed to get member from state store")
+		} else if member != nil && member.Membership == event.MembershipBan {
+			manualBans = append(manualBans, fmt.Sprintf("* [%s](%s)", roomID, roomID.URI().MatrixToURL()))
+		}
+	}
+	if unbannedCount == 0 && len(manualBans) == 0 {
+		return
+	}
+	zerolog.Ctx(ctx).Info().
+		Stringer("user_id", userID).
+		Int("unbanned_count", unbannedCount).
+		Int("manual_ban_count", len(manualBans)).
+		Msg("Applied unban recommendation")
+	output := fmt.Sprintf("Applying unban recommendation for [%s](%s): lifting %s placed by Meowlnir",
+		userID, userID.URI().MatrixToURL(), pluralize(unbannedCount, "ban"))
+	if len(manualBans) > 0 {
+		output += fmt.Sprintf(". Left %s placed manually by room moderators:\n\n%s",
+			pluralize(len(manualBans), "ban"), strings.Join(manualBans, "\n"))
+	}
+	pe.sendNotice(ctx, output)
+}
+
+// isBanQueued checks if a policy ban for the user is already queued in the room and not followed by an unban,
+// so that re-evaluating policies before the queue is processed doesn't queue duplicate bans.
+//
+// Users who are already banned don't need to be checked here, as they're not included in the room member list.
+func (pe *PolicyEvaluator) isBanQueued(ctx context.Context, userID id.UserID, roomID id.RoomID) bool {
+	queued, err := pe.DB.QueuedAction.GetBansAndUnbans(ctx, pe.ManagementRoom, roomID)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).
+			Stringer("user_id", userID).
+			Stringer("room_id", roomID).
+			Msg("Failed to check for queued bans")
+		return false
+	}
+	var banQueued bool
+	for _, qa := range queued {
+		if qa.Payload.UserID == userID {
+			// Temporary bans don't have a taken action, they don't count as they'll be lifted later
+			banQueued = qa.Type == database.QueuedActionTypeBan && qa.Payload.TakenAction != nil
 		}
 	}
+	return banQueued
+}
+
+// lockBanQueue locks queueing bans in the given room, so that concurrent evaluations don't both queue a ban.
+func (pe *PolicyEvaluator) lockBanQueue(roomID id.RoomID) func() {
+	pe.banQueueLock.Lock()
+	lock, ok := pe.banQueueLocks[roomID]
+	if !ok {
+		lock = &sync.Mutex{}
+		pe.banQueueLocks[roomID] = lock
+	}
+	pe.banQueueLock.Unlock()
+	lock.Lock()
+	return lock.Unlock
 }
 
 func (pe *PolicyEvaluator) ApplyBan(ctx context.Context, userID id.UserID, roomID id.RoomID, policy *policylist.Policy) {
+	unlock := pe.lockBanQueue(roomID)
+	defer unlock()
+	if pe.isBanQueued(ctx, userID, roomID) {
+		zerolog.Ctx(ctx).Debug().
+			Stringer("user_id", userID).
+			Stringer("room_id", roomID).
+			Msg("Not queueing ban as the user already has a ban queued")
+		return
+	}
 	ta := &database.TakenAction{
 		TargetUser: userID,
 		InRoomID:   roomID,
 		ActionType: database.TakenActionTypeBanOrUnban,
 		PolicyList: policy.RoomID,
-		RuleEntity: policy.Entity,
+		RuleEntity: policy.EntityOrHash(),
+		RuleType:   string(policy.EntityType),
 		Action:     policy.Recommendation,
 		TakenAt:    time.Now(),
 	}
-	var err error
-	if !pe.DryRun {
-		_, err = pe.Bot.BanUser(ctx, roomID, &mautrix.ReqBanUser{
-			Reason: policy.Reason,
-			UserID: userID,
-		})
-	}
-	if err != nil {
-		var respErr mautrix.HTTPError
-		if errors.As(err, &respErr) {
-			err = respErr
-		}
-		zerolog.Ctx(ctx).Err(err).Any("attempted_action", ta).Msg("Failed to ban user")
-		pe.sendNotice(ctx, "Failed to ban [%s](%s) in [%s](%s) for %s: %v", userID, userID.URI().MatrixToURL(), roomID, roomID.URI().MatrixToURL(), policy.Reason, err)
-		return
-	}
-	err = pe.DB.TakenAction.Put(ctx, ta)
-	if err != nil {
-		zerolog.Ctx(ctx).Err(err).Any("taken_action", ta).Msg("Failed to save taken action")
-		pe.sendNotice(ctx, "Banned [%s](%s) in [%s](%s) for %s, but failed to save to database: %v", userID, userID.URI().MatrixToURL(), roomID, roomID.URI().MatrixToURL(), policy.Reason, err)
-	} else {
-		zerolog.Ctx(ctx).Info().Any("taken_action", ta).Msg("Took action")
-		pe.sendNotice(ctx, "Banned [%s](%s) in [%s](%s) for %s", userID, userID.URI().MatrixToURL(), roomID, roomID.URI().MatrixToURL(), policy.Reason)
-	}
+	_ = pe.queueAction(ctx, roomID, database.QueuedActionTypeBan, &database.QueuedActionPayload{
+		UserID:      userID,
+		Reason:      policy.Reason,
+		TakenAction: ta,
+	})
+}
+
+// ApplyUnban queues an unban for a user that was previously banned by Meowlnir.
+// The return value is true if the unban was queued successfully.
+func (pe *PolicyEvaluator) ApplyUnban(ctx context.Context, ta *database.TakenAction, reason string) bool {
+	err := pe.queueAction(ctx, ta.InRoomID, database.QueuedActionTypeUnban, &database.QueuedActionPayload{
+		UserID:      ta.TargetUser,
+		Reason:      reason,
+		TakenAction: ta,
+	})
+	return err == nil
+}
+
+// KickUser queues a kick for the given user in the given room.
+func (pe *PolicyEvaluator) KickUser(ctx context.Context, userID id.UserID, roomID id.RoomID, reason string) bool {
+	err := pe.queueAction(ctx, roomID, database.QueuedActionTypeKick, &database.QueuedActionPayload{
+		UserID: userID,
+		Reason: reason,
+	})
+	return err == nil
 }
 
 func pluralize(value int, unit string) string {
@@ -108,8 +228,13 @@ func pluralize(value int, unit string) string {
 	return fmt.Sprintf("%d %ss", value, unit)
 }
 
+// RedactUser redacts all events the given user has sent in protected rooms.
 func (pe *PolicyEvaluator) RedactUser(ctx context.Context, userID id.UserID, reason string, allowReredact bool) {
-	events, maxTS, err := pe.SynapseDB.GetEventsToRedact(ctx, userID, pe.GetProtectedRooms())
+	pe.redactUserSince(ctx, userID, reason, time.Time{}, allowReredact)
+}
+
+func (pe *PolicyEvaluator) redactUserSince(ctx context.Context, userID id.UserID, reason string, since time.Time, allowReredact bool) {
+	events, maxTS, err := pe.SynapseDB.GetEventsToRedact(ctx, userID, pe.GetProtectedRooms(), since)
 	if err != nil {
 		zerolog.Ctx(ctx).Err(err).
 			Stringer("user_id", userID).
@@ -122,55 +247,23 @@ func (pe *PolicyEvaluator) RedactUser(ctx context.Context, userID id.UserID, rea
 		return
 	}
 	needsReredact := allowReredact && time.Since(maxTS) < 5*time.Minute
-	var errorMessages []string
-	var redactedCount int
+	var eventCount, queuedRooms int
 	for roomID, roomEvents := range events {
-		successCount, failedCount := pe.redactEventsInRoom(ctx, userID, roomID, roomEvents, reason)
-		if failedCount > 0 {
-			errorMessages = append(errorMessages, fmt.Sprintf(
-				"* Failed to redact %d/%d events from [%s](%s) in [%s](%s)",
-				failedCount, failedCount+successCount, userID, userID.URI().MatrixToURL(), roomID, roomID.URI().MatrixToURL()))
+		err = pe.queueAction(ctx, roomID, database.QueuedActionTypeRedact, &database.QueuedActionPayload{
+			UserID:   userID,
+			Reason:   reason,
+			EventIDs: roomEvents,
+		})
+		if err == nil {
+			eventCount += len(roomEvents)
+			queuedRooms++
 		}
-		redactedCount += successCount
 	}
-	output := fmt.Sprintf("Redacted %s across %s from [%s](%s)",
-		pluralize(redactedCount, "event"), pluralize(len(events), "room"),
+	pe.sendNotice(ctx, "Queued redaction of %s across %s from [%s](%s)",
+		pluralize(eventCount, "event"), pluralize(queuedRooms, "room"),
 		userID, userID.URI().MatrixToURL())
-	if len(errorMessages) > 0 {
-		output += "\n\n" + strings.Join(errorMessages, "\n")
-	}
-	pe.sendNotice(ctx, output)
 	if needsReredact {
 		time.Sleep(15 * time.Second)
-		pe.RedactUser(ctx, userID, reason, false)
-	}
-}
-
-func (pe *PolicyEvaluator) redactEventsInRoom(ctx context.Context, userID id.UserID, roomID id.RoomID, events []id.EventID, reason string) (successCount, failedCount int) {
-	for _, evtID := range events {
-		var resp *mautrix.RespSendEvent
-		var err error
-		if !pe.DryRun {
-			resp, err = pe.Bot.RedactEvent(ctx, roomID, evtID, mautrix.ReqRedact{Reason: reason})
-		} else {
-			resp = &mautrix.RespSendEvent{EventID: "$fake-redaction-id"}
-		}
-		if err != nil {
-			zerolog.Ctx(ctx).Err(err).
-				Stringer("sender", userID).
-				Stringer("room_id", roomID).
-				Stringer("event_id", evtID).
-				Msg("Failed to redact event")
-			failedCount++
-		} else {
-			zerolog.Ctx(ctx).Debug().
-				Stringer("sender", userID).
-				Stringer("room_id", roomID).
-				Stringer("event_id", evtID).
-				Stringer("redaction_id", resp.EventID).
-				Msg("Successfully redacted event")
-			successCount++
-		}
+		pe.redactUserSince(ctx, userID, reason, since, false)
 	}
-	return
 }
@@ -0,0 +1,135 @@
+package policyeval
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/meowlnir/database"
+	"go.mau.fi/meowlnir/policylist"
+)
+
+type policyStateKey struct {
+	Type     string
+	StateKey string
+}
+
+// loadPolicyList adds the given policy room to the policy store.
+//
+// If the room state is cached in the database, the cache is used and the live state is reconciled in the background.
+// Otherwise, the state is fetched from the homeserver and saved to the database. Lists are only considered cached
+// after their full state has been saved, as individual events may be cached before that.
+func (pe *PolicyEvaluator) loadPolicyList(ctx context.Context, roomID id.RoomID) error {
+	isCached, err := pe.DB.PolicyEvent.IsRoomCached(ctx, roomID)
+	if err != nil {
+		return fmt.Errorf("failed to check if state is cached: %w", err)
+	} else if isCached {
+		cached, err := pe.DB.PolicyEvent.GetAllByRoom(ctx, roomID)
+		if err != nil {
+			return fmt.Errorf("failed to get cached state: %w", err)
+		}
+		state := make(map[event.Type]map[string]*event.Event)
+		for _, row := range cached {
+			evt := row.ToEvent()
+			if state[evt.Type] == nil {
+				state[evt.Type] = make(map[string]*event.Event)
+			}
+			state[evt.Type][row.StateKey] = evt
+		}
+		pe.Store.Add(roomID, state)
+		zerolog.Ctx(ctx).Debug().
+			Stringer("policy_list_id", roomID).
+			Int("event_count", len(cached)).
+			Msg("Loaded policy list from cache")
+		go pe.reconcilePolicyList(context.WithoutCancel(ctx), roomID, cached)
+		return nil
+	}
+	state, err := pe.Bot.State(ctx, roomID)
+	if err != nil {
+		return err
+	}
+	pe.Store.Add(roomID, state)
+	var rows []*database.PolicyEvent
+	for evtType, evts := range state {
+		if !policylist.IsPolicyEventType(evtType) {
+			continue
+		}
+		for _, evt := range evts {
+			evt.RoomID = roomID
+			rows = append(rows, database.PolicyEventFromEvent(evt))
+		}
+	}
+	err = pe.DB.PolicyEvent.PutRoomState(ctx, roomID, rows)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Stringer("policy_list_id", roomID).Msg("Failed to cache policy list state")
+	}
+	return nil
+}
+
+// uncachePolicyLists deletes the cached state of policy lists that are no longer watched.
+// The cache is shared by all management rooms, so lists that another management room watches are kept.
+func (pe *PolicyEvaluator) uncachePolicyLists(ctx context.Context, roomIDs []id.RoomID) {
+	for _, roomID := range roomIDs {
+		if pe.isListWatched(roomID) {
+			continue
+		}
+		err := pe.DB.PolicyEvent.DeleteRoom(ctx, roomID)
+		if err != nil {
+			zerolog.Ctx(ctx).Err(err).Stringer("policy_list_id", roomID).Msg("Failed to delete cached state of unwatched policy list")
+		}
+	}
+}
+
+// reconcilePolicyList fetches the live state of a policy room that was loaded from the cache
+// and passes all changes through the normal policy list update flow.
+func (pe *PolicyEvaluator) reconcilePolicyList(ctx context.Context, roomID id.RoomID, cached []*database.PolicyEvent) {
+	log := zerolog.Ctx(ctx).With().
+		Str("action", "reconcile policy list").
+		Stringer("policy_list_id", roomID).
+		Logger()
+	ctx = log.WithContext(ctx)
+	state, err := pe.Bot.State(ctx, roomID)
+	if err != nil {
+		log.Err(err).Msg("Failed to get live state of policy list")
+		pe.sendNotice(ctx, "Failed to get live state of cached policy list [%s](%s): %v", roomID, roomID.URI().MatrixToURL(), err)
+		return
+	}
+	cachedByKey := make(map[policyStateKey]*database.PolicyEvent, len(cached))
+	for _, row := range cached {
+		cachedByKey[policyStateKey{Type: row.Type.Type, StateKey: row.StateKey}] = row
+	}
+	var changed int
+	for evtType, evts := range state {
+		if !policylist.IsPolicyEventType(evtType) {
+			continue
+		}
+		for stateKey, evt := range evts {
+			key := policyStateKey{Type: evtType.Type, StateKey: stateKey}
+			existing, ok := cachedByKey[key]
+			delete(cachedByKey, key)
+			if ok && (existing.EventID == evt.ID || existing.Timestamp > evt.Timestamp) {
+				// Either unchanged, or a newer event has already been received after fetching the state
+				continue
+			}
+			evt.RoomID = roomID
+			pe.updatePolicyList(ctx, evt)
+			changed++
+		}
+	}
+	for _, row := range cachedByKey {
+		// The cached event isn't in the room state anymore, so treat it as removed
+		evt := row.ToEvent()
+		evt.Content = event.Content{Parsed: &event.ModPolicyContent{}}
+		pe.updatePolicyList(ctx, evt)
+		err = pe.DB.PolicyEvent.Delete(ctx, roomID, row.Type, row.StateKey)
+		if err != nil {
+			log.Err(err).Any("policy_type", row.Type).Str("state_key", row.StateKey).
+				Msg("Failed to delete removed policy from cache")
+		}
+		changed++
+	}
+	log.Info().Int("changed_count", changed).Msg("Reconciled cached policy list state")
+}
@@ -0,0 +1,136 @@
+package policyeval
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/meowlnir/config"
+	"go.mau.fi/meowlnir/policylist"
+)
+
+// stateShortcode is the state event used by Mjolnir and Draupnir to store the shortcode of a policy list.
+var stateShortcode = event.Type{Type: "org.matrix.mjolnir.shortcode", Class: event.StateEventType}
+
+func init() {
+	registerCommands(&CommandHandler{
+		Name:        "list",
+		Description: "Create and administer policy lists.",
+		Subcommands: []*CommandHandler{{
+			Name:        "create",
+			Usage:       "<shortcode> <name>",
+			Description: "Create a new policy list, invite the admins and start watching it.",
+			MinArgs:     2,
+			Func:        (*PolicyEvaluator).cmdListCreate,
+		}, {
+			Name:        "check",
+			Description: "Check that the bot can send policies to all watched lists.",
+			Func:        (*PolicyEvaluator).cmdListCheck,
+		}},
+	})
+}
+
+func (pe *PolicyEvaluator) cmdListCreate(ctx context.Context, ce *CommandEvent) {
+	pe.configEditLock.Lock()
+	defer pe.configEditLock.Unlock()
+	shortcode := ce.Args[0]
+	name := strings.Join(ce.Args[1:], " ")
+	lists, err := getManagementList[config.WatchedPolicyList](ctx, pe, config.StateWatchedLists, "lists")
+	if err != nil {
+		ce.ReplyError(ctx, "Failed to get current watched lists: %v", err)
+		return
+	} else if slices.ContainsFunc(lists.Items, func(list config.WatchedPolicyList) bool {
+		return strings.EqualFold(list.Shortcode, shortcode)
+	}) {
+		ce.ReplyError(ctx, "There's already a list with the shortcode `%s`", shortcode)
+		return
+	}
+	admins := slices.DeleteFunc(pe.Admins.AsList(), func(userID id.UserID) bool {
+		return userID == pe.Bot.UserID
+	})
+	slices.Sort(admins)
+	powerLevels := &event.PowerLevelsEventContent{
+		Users:         map[id.UserID]int{pe.Bot.UserID: 100},
+		EventsDefault: 50,
+	}
+	for _, admin := range admins {
+		powerLevels.Users[admin] = 50
+	}
+	resp, err := pe.Bot.CreateRoom(ctx, &mautrix.ReqCreateRoom{
+		Name:   name,
+		Preset: "private_chat",
+		Invite: admins,
+		InitialState: []*event.Event{{
+			Type:    event.StateHistoryVisibility,
+			Content: event.Content{Parsed: &event.HistoryVisibilityEventContent{HistoryVisibility: event.HistoryVisibilityShared}},
+		}, {
+			Type:    stateShortcode,
+			Content: event.Content{Raw: map[string]any{"shortcode": shortcode}},
+		}},
+		PowerLevelOverride: powerLevels,
+	})
+	if err != nil {
+		ce.ReplyError(ctx, "Failed to create room: %v", err)
+		return
+	}
+	zerolog.Ctx(ctx).Info().
+		Stringer("room_id", resp.RoomID).
+		Str("shortcode", shortcode).
+		Msg("Created new policy list")
+	err = lists.Append(config.WatchedPolicyList{
+		RoomID:    resp.RoomID,
+		Name:      name,
+		Shortcode: shortcode,
+	})
+	if err == nil {
+		err = lists.Save(ctx, pe)
+	}
+	if err != nil {
+		ce.ReplyError(ctx, "Created [%s](%s), but failed to add it to watched lists: %v", name, resp.RoomID.URI().MatrixToURL(), err)
+		return
+	}
+	ce.React(ctx)
+}
+
+var policyEventTypes = []event.Type{
+	policylist.EntityTypeUser.EventType(),
+	policylist.EntityTypeRoom.EventType(),
+	policylist.EntityTypeServer.EventType(),
+}
+
+func (pe *PolicyEvaluator) cmdListCheck(ctx context.Context, ce *CommandEvent) {
+	lists := pe.GetAllWatchedLists()
+	if len(lists) == 0 {
+		ce.Reply(ctx, "Not watching any lists")
+		return
+	}
+	lines := make([]string, len(lists))
+	for i, list := range lists {
+		listLink := fmt.Sprintf("[%s](%s) (`%s`)", list.Name, list.RoomID.URI().MatrixToURL(), list.Shortcode)
+		var powerLevels event.PowerLevelsEventContent
+		err := pe.Bot.StateEvent(ctx, list.RoomID, event.StatePowerLevels, "", &powerLevels)
+		if err != nil {
+			lines[i] = fmt.Sprintf("* ❌ %s: failed to get power levels: %v", listLink, err)
+			continue
+		}
+		ownLevel := powerLevels.GetUserLevel(pe.Bot.UserID)
+		var missing []string
+		for _, evtType := range policyEventTypes {
+			if required := powerLevels.GetEventLevel(evtType); ownLevel < required {
+				missing = append(missing, fmt.Sprintf("`%s` (requires %d)", evtType.Type, required))
+			}
+		}
+		if len(missing) == 0 {
+			lines[i] = fmt.Sprintf("* ✅ %s: can send all policy types", listLink)
+		} else {
+			lines[i] = fmt.Sprintf("* ❌ %s: power level %d is too low to send %s", listLink, ownLevel, strings.Join(missing, ", "))
+		}
+	}
+	ce.Reply(ctx, strings.Join(lines, "\n"))
+}
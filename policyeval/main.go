@@ -3,6 +3,7 @@ package policyeval
 import (
 	"context"
 	"fmt"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -26,20 +27,38 @@ type PolicyEvaluator struct {
 	DB        *database.Database
 	DryRun    bool
 
+	reportReactions config.ReportReactionsConfig
+	reportThreshold config.ReportThresholdConfig
+
 	ManagementRoom id.RoomID
 	Admins         *exsync.Set[id.UserID]
 
 	watchedListsMap  map[id.RoomID]*config.WatchedPolicyList
 	watchedListsList []id.RoomID
+	watchedListsAll  []*config.WatchedPolicyList
 	watchedListsLock sync.RWMutex
 
-	configLock sync.Mutex
+	configLock     sync.Mutex
+	configEditLock sync.Mutex
 
 	claimProtected       func(roomID id.RoomID, eval *PolicyEvaluator, claim bool) *PolicyEvaluator
+	updatePolicyList     func(ctx context.Context, evt *event.Event)
+	isRoomUsedByBot      func(userID id.UserID, roomID id.RoomID) bool
+	isListWatched        func(roomID id.RoomID) bool
 	protectedRooms       map[id.RoomID]struct{}
-	wantToProtect        map[id.RoomID]struct{}
+	wantToProtect        map[id.RoomID]string
 	protectedRoomMembers map[id.UserID][]id.RoomID
 	protectedRoomsLock   sync.RWMutex
+
+	aclDeferChan chan struct{}
+
+	queueWorkers  map[id.RoomID]chan struct{}
+	queueLock     sync.Mutex
+	banQueueLocks map[id.RoomID]*sync.Mutex
+	banQueueLock  sync.Mutex
+
+	reportTargetLocks     map[id.UserID]*reportTargetLock
+	reportTargetLocksLock sync.Mutex
 }
 
 func NewPolicyEvaluator(
@@ -49,7 +68,12 @@ func NewPolicyEvaluator(
 	db *database.Database,
 	synapseDB *synapsedb.SynapseDB,
 	claimProtected func(roomID id.RoomID, eval *PolicyEvaluator, claim bool) *PolicyEvaluator,
+	updatePolicyList func(ctx context.Context, evt *event.Event),
+	isRoomUsedByBot func(userID id.UserID, roomID id.RoomID) bool,
+	isListWatched func(roomID id.RoomID) bool,
 	dryRun bool,
+	reportReactions config.ReportReactionsConfig,
+	reportThreshold config.ReportThresholdConfig,
 ) *PolicyEvaluator {
 	pe := &PolicyEvaluator{
 		Bot:                  bot,
@@ -61,11 +85,21 @@ func NewPolicyEvaluator(
 		protectedRoomMembers: make(map[id.UserID][]id.RoomID),
 		watchedListsMap:      make(map[id.RoomID]*config.WatchedPolicyList),
 		protectedRooms:       make(map[id.RoomID]struct{}),
-		wantToProtect:        make(map[id.RoomID]struct{}),
+		wantToProtect:        make(map[id.RoomID]string),
 		claimProtected:       claimProtected,
+		updatePolicyList:     updatePolicyList,
+		isRoomUsedByBot:      isRoomUsedByBot,
+		isListWatched:        isListWatched,
+		aclDeferChan:         make(chan struct{}, 1),
+		queueWorkers:         make(map[id.RoomID]chan struct{}),
+		banQueueLocks:        make(map[id.RoomID]*sync.Mutex),
+		reportTargetLocks:    make(map[id.UserID]*reportTargetLock),
 
-		DryRun: dryRun,
+		DryRun:          dryRun,
+		reportReactions: reportReactions,
+		reportThreshold: reportThreshold,
 	}
+	go pe.aclDeferLoop()
 	return pe
 }
 
@@ -117,9 +151,13 @@ func (pe *PolicyEvaluator) tryLoad(ctx context.Context) error {
 		_, errorMsgs := pe.handleProtectedRooms(ctx, evt, true)
 		errors = append(errors, errorMsgs...)
 	}
+	pe.ResumeQueuedActions(ctx)
 	initDuration := time.Since(start)
 	start = time.Now()
 	pe.EvaluateAll(ctx)
+	pe.reevaluateAfterLoad(ctx)
+	pe.UpdateACL(ctx)
+	pe.EvaluateJoinedRooms(ctx)
 	evalDuration := time.Since(start)
 	pe.protectedRoomsLock.Lock()
 	userCount := len(pe.protectedRoomMembers)
@@ -144,6 +182,15 @@ func (pe *PolicyEvaluator) tryLoad(ctx context.Context) error {
 	return nil
 }
 
+// reevaluateAfterLoad checks if the bans from applied lists are still valid. Policies that expired or were removed
+// while Meowlnir was offline are never added to the store, so the normal removed rule handling doesn't see them.
+func (pe *PolicyEvaluator) reevaluateAfterLoad(ctx context.Context) {
+	lists := slices.DeleteFunc(slices.Clone(pe.GetAllWatchedLists()), func(list *config.WatchedPolicyList) bool {
+		return list.DontApply
+	})
+	pe.ReevaluateAffectedByLists(ctx, lists)
+}
+
 func (pe *PolicyEvaluator) handlePowerLevels(evt *event.Event) string {
 	content, ok := evt.Content.Parsed.(*event.PowerLevelsEventContent)
 	if !ok {
@@ -0,0 +1,168 @@
+package policyeval
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mau.fi/util/glob"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/meowlnir/policylist"
+)
+
+func init() {
+	registerCommands(&CommandHandler{
+		Name:        "match",
+		Usage:       "<user ID, room ID, room alias or server name>",
+		Description: "Find all policies in watched lists that match the entity and show which one is applied.",
+		MinArgs:     1,
+		Func:        (*PolicyEvaluator).cmdMatch,
+	})
+}
+
+// matchEntity matches the given entity against the given lists. For users, all user rules matching the user ID
+// and all server rules matching the user's server are included, except for server rules matching our own server.
+func (pe *PolicyEvaluator) matchEntity(entityType policylist.EntityType, entity string, listIDs []id.RoomID) policylist.Match {
+	switch entityType {
+	case policylist.EntityTypeUser:
+		userID := id.UserID(entity)
+		match := pe.Store.MatchUser(listIDs, userID)
+		if server := userID.Homeserver(); server != pe.Bot.UserID.Homeserver() {
+			match = append(match, pe.Store.MatchServer(listIDs, server)...)
+		}
+		return match
+	case policylist.EntityTypeRoom:
+		return pe.Store.MatchRoom(listIDs, id.RoomID(entity))
+	case policylist.EntityTypeServer:
+		return pe.Store.MatchServer(listIDs, entity)
+	default:
+		return nil
+	}
+}
+
+func (pe *PolicyEvaluator) cmdMatch(ctx context.Context, ce *CommandEvent) {
+	entity := ce.Args[0]
+	var entityType policylist.EntityType
+	switch entity[0] {
+	case '@':
+		entityType = policylist.EntityTypeUser
+	case '!', '#':
+		roomID, err := pe.resolveRoom(ctx, entity)
+		if err != nil {
+			ce.ReplyError(ctx, "Failed to find room: %v", err)
+			return
+		}
+		entity = string(roomID)
+		entityType = policylist.EntityTypeRoom
+	default:
+		entityType = policylist.EntityTypeServer
+	}
+	var notAppliedLists []id.RoomID
+	for _, list := range pe.GetAllWatchedLists() {
+		if list.DontApply {
+			notAppliedLists = append(notAppliedLists, list.RoomID)
+		}
+	}
+	start := time.Now()
+	applied := pe.matchEntity(entityType, entity, pe.GetWatchedLists())
+	var notApplied policylist.Match
+	if len(notAppliedLists) > 0 {
+		notApplied = pe.matchEntity(entityType, entity, notAppliedLists)
+	}
+	dur := time.Since(start)
+	if len(applied) == 0 && len(notApplied) == 0 {
+		ce.Reply(ctx, "No match for %s `%s` in %s", entityType, entity, dur)
+		return
+	}
+	winner := applied.Recommendations().BanOrUnban
+	var out strings.Builder
+	fmt.Fprintf(&out, "Matched %s `%s` in %s\n\n", entityType, entity, dur)
+	if len(applied) > 0 {
+		fmt.Fprintf(&out, "**Applied lists** (%d)\n\n", len(applied))
+		for _, policy := range applied {
+			out.WriteString(pe.formatMatchedPolicy(policy, policy == winner))
+		}
+	}
+	if len(notApplied) > 0 {
+		fmt.Fprintf(&out, "\n**Lists that aren't applied** (%d)\n\n", len(notApplied))
+		for _, policy := range notApplied {
+			out.WriteString(pe.formatMatchedPolicy(policy, false))
+		}
+	}
+	if winner != nil {
+		fmt.Fprintf(
+			&out, "\nRecommendation `%s` from %s is applied, because %s.",
+			winner.Recommendation, pe.listName(winner.RoomID), pe.explainRecommendation(applied, winner),
+		)
+	} else {
+		out.WriteString("\nNo ban, unban or takedown recommendation is applied.")
+	}
+	ce.Reply(ctx, strings.TrimSpace(out.String()))
+}
+
+func (pe *PolicyEvaluator) formatMatchedPolicy(policy *policylist.Policy, isWinner bool) string {
+	var prefix, suffix string
+	if isWinner {
+		prefix = "➡️ "
+	}
+	if policy.IsHashed() {
+		suffix = " (matched a hashed rule)"
+	}
+	listInfo := policy.RoomID.String()
+	if meta := pe.GetWatchedListMeta(policy.RoomID); meta != nil {
+		listInfo = fmt.Sprintf("%s (`%s`)", meta.Name, meta.Shortcode)
+	}
+	return fmt.Sprintf(
+		"* %s`%s` for %s `%s` in %s by [%s](%s) at %s: %s%s\n",
+		prefix, policy.Recommendation, policy.EntityType, policy.EntityOrHash(), listInfo,
+		policy.Sender, policy.Sender.URI().MatrixToURL(), formatTime(time.UnixMilli(policy.Timestamp)),
+		policy.Reason, suffix,
+	)
+}
+
+func isBanOrUnban(rec event.PolicyRecommendation) bool {
+	return policylist.IsBanRecommendation(rec) || rec == event.PolicyRecommendationUnban
+}
+
+func isExactPolicy(policy *policylist.Policy) bool {
+	_, isExact := policy.Pattern.(glob.ExactGlob)
+	return isExact || policy.IsHashed()
+}
+
+// explainRecommendation describes why Match.Recommendations picked the given policy
+// over the other ban and unban policies in the match.
+func (pe *PolicyEvaluator) explainRecommendation(match policylist.Match, winner *policylist.Policy) string {
+	candidates := slices.DeleteFunc(slices.Clone(match), func(policy *policylist.Policy) bool {
+		return policy == winner || !isBanOrUnban(policy.Recommendation)
+	})
+	if len(candidates) == 0 {
+		return "it's the only matching ban, unban or takedown rule"
+	}
+	if slices.Index(match, winner) > slices.Index(match, candidates[0]) {
+		// Only takedowns can win over a ban or unban that comes before them.
+		return "takedowns take priority over bans and unbans"
+	}
+	// The winner is the first ban or unban in the match, explain why it comes before the next one.
+	next := candidates[0]
+	var reason string
+	switch {
+	case winner.EntityType != next.EntityType:
+		reason = fmt.Sprintf("%s rules are checked before %s rules", winner.EntityType, next.EntityType)
+	case winner.RoomID != next.RoomID:
+		reason = fmt.Sprintf("%s is before %s in the list order", pe.listName(winner.RoomID), pe.listName(next.RoomID))
+	case isExactPolicy(winner) && !isExactPolicy(next):
+		reason = "exact rules are checked before glob rules in the same list"
+	default:
+		reason = "it's the most recently added matching glob rule in the list"
+	}
+	if winner.Recommendation == event.PolicyRecommendationUnban && slices.ContainsFunc(candidates, func(policy *policylist.Policy) bool {
+		return policylist.IsBanRecommendation(policy.Recommendation)
+	}) {
+		reason += ", and an unban overrides all bans that come after it"
+	}
+	return reason
+}
@@ -0,0 +1,138 @@
+package policyeval
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"go.mau.fi/meowlnir/policylist"
+)
+
+const policyPageSize = 50
+
+func init() {
+	registerCommands(&CommandHandler{
+		Name:        "policies",
+		Description: "Search and browse the policies in policy lists.",
+		Subcommands: []*CommandHandler{{
+			Name:        "search",
+			Usage:       "<text> [page]",
+			Description: "Search entities and reasons of policies in all lists.",
+			MinArgs:     1,
+			Func:        (*PolicyEvaluator).cmdPoliciesSearch,
+		}, {
+			Name:        "list",
+			Usage:       "<list shortcode> [user|room|server] [page]",
+			Description: "List the policies in the given list.",
+			MinArgs:     1,
+			Func:        (*PolicyEvaluator).cmdPoliciesList,
+		}},
+	})
+}
+
+// cutPageArg removes a trailing page number from the arguments if there is one.
+// At least minArgs arguments are always left in the output.
+func cutPageArg(args []string, minArgs int) ([]string, int) {
+	if len(args) > minArgs {
+		page, err := strconv.Atoi(args[len(args)-1])
+		if err == nil && page > 0 {
+			return args[:len(args)-1], page
+		}
+	}
+	return args, 1
+}
+
+func sortPolicies(policies []*policylist.Policy) {
+	slices.SortFunc(policies, func(a, b *policylist.Policy) int {
+		return cmp.Or(
+			cmp.Compare(a.RoomID, b.RoomID),
+			cmp.Compare(a.EntityType, b.EntityType),
+			cmp.Compare(a.EntityOrHash(), b.EntityOrHash()),
+			cmp.Compare(a.StateKey, b.StateKey),
+		)
+	})
+}
+
+func (pe *PolicyEvaluator) cmdPoliciesSearch(ctx context.Context, ce *CommandEvent) {
+	args, page := cutPageArg(ce.Args, 1)
+	text := strings.Join(args, " ")
+	policies := pe.Store.Search(text)
+	if len(policies) == 0 {
+		ce.Reply(ctx, "No policies found matching `%s`", text)
+		return
+	}
+	sortPolicies(policies)
+	nextPage := fmt.Sprintf("`%s %q %d`", ce.Handler.FullName(), text, page+1)
+	ce.Reply(ctx, pe.formatPolicyPage(policies, page, true, nextPage))
+}
+
+func (pe *PolicyEvaluator) cmdPoliciesList(ctx context.Context, ce *CommandEvent) {
+	list := pe.FindListByShortcode(ce.Args[0])
+	if list == nil {
+		ce.ReplyError(ctx, `List %q not found`, ce.Args[0])
+		return
+	}
+	room := pe.Store.GetRoom(list.RoomID)
+	if room == nil {
+		ce.ReplyError(ctx, "List %s is not loaded", list.Name)
+		return
+	}
+	args, page := cutPageArg(ce.Args[1:], 0)
+	var policies []*policylist.Policy
+	var typeArg string
+	if len(args) > 0 {
+		typeArg = " " + strings.ToLower(args[0])
+		switch policylist.EntityType(strings.ToLower(args[0])) {
+		case policylist.EntityTypeUser:
+			policies = room.UserRules.All()
+		case policylist.EntityTypeRoom:
+			policies = room.RoomRules.All()
+		case policylist.EntityTypeServer:
+			policies = room.ServerRules.All()
+		default:
+			ce.ReplyUsage(ctx)
+			return
+		}
+	} else {
+		policies = append(policies, room.UserRules.All()...)
+		policies = append(policies, room.RoomRules.All()...)
+		policies = append(policies, room.ServerRules.All()...)
+	}
+	if len(policies) == 0 {
+		ce.Reply(ctx, "No%s policies in %s", typeArg, list.Name)
+		return
+	}
+	sortPolicies(policies)
+	nextPage := fmt.Sprintf("`%s %s%s %d`", ce.Handler.FullName(), list.Shortcode, typeArg, page+1)
+	ce.Reply(ctx, pe.formatPolicyPage(policies, page, false, nextPage))
+}
+
+// formatPolicyPage formats one page of the given policies. nextPageCommand is shown if there are more pages.
+func (pe *PolicyEvaluator) formatPolicyPage(policies []*policylist.Policy, page int, includeList bool, nextPageCommand string) string {
+	pages := (len(policies) + policyPageSize - 1) / policyPageSize
+	if page > pages {
+		return fmt.Sprintf("Page %d doesn't exist, there are only %d pages", page, pages)
+	}
+	start := (page - 1) * policyPageSize
+	end := min(start+policyPageSize, len(policies))
+	var out strings.Builder
+	fmt.Fprintf(&out, "Showing %d-%d of %d policies (page %d/%d)\n\n", start+1, end, len(policies), page, pages)
+	for _, policy := range policies[start:end] {
+		fmt.Fprintf(&out, "* `%s` for %s `%s`", policy.Recommendation, policy.EntityType, policy.EntityOrHash())
+		if includeList {
+			fmt.Fprintf(&out, " in %s", pe.listName(policy.RoomID))
+		}
+		fmt.Fprintf(&out, ": %s", policy.Reason)
+		if policy.Ignored {
+			out.WriteString(" (ignored)")
+		}
+		out.WriteString("\n")
+	}
+	if page < pages {
+		fmt.Fprintf(&out, "\nUse %s to see the next page.", nextPageCommand)
+	}
+	return strings.TrimSpace(out.String())
+}
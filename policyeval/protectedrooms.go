@@ -49,7 +49,7 @@ func (pe *PolicyEvaluator) HandleProtectedRoomPowerLevels(ctx context.Context, e
 	}
 }
 
-func (pe *PolicyEvaluator) tryProtectingRoom(ctx context.Context, joinedRooms *mautrix.RespJoinedRooms, roomID id.RoomID, doReeval bool) (*mautrix.RespMembers, string) {
+func (pe *PolicyEvaluator) tryProtectingRoom(ctx context.Context, joinedRooms *mautrix.RespJoinedRooms, roomID id.RoomID, doReeval bool) (members *mautrix.RespMembers, errMsg string) {
 	if claimer := pe.claimProtected(roomID, pe, true); claimer != pe {
 		if claimer != nil && claimer.Bot.UserID == pe.Bot.UserID {
 			return nil, fmt.Sprintf("* Room [%s](%s) is already protected by [%s](%s)", roomID, roomID.URI().MatrixToURL(), claimer.ManagementRoom, claimer.ManagementRoom.URI().MatrixToURL())
@@ -65,6 +65,11 @@ func (pe *PolicyEvaluator) tryProtectingRoom(ctx context.Context, joinedRooms *m
 		}
 	}
 	pe.markAsWantToProtect(roomID)
+	defer func() {
+		if errMsg != "" {
+			pe.setProtectFailure(roomID, strings.TrimPrefix(errMsg, "* "))
+		}
+	}()
 	if !slices.Contains(joinedRooms.JoinedRooms, roomID) {
 		return nil, fmt.Sprintf("* Bot is not in protected room [%s](%s)", roomID, roomID.URI().MatrixToURL())
 	}
@@ -78,7 +83,7 @@ func (pe *PolicyEvaluator) tryProtectingRoom(ctx context.Context, joinedRooms *m
 	if ownLevel < minLevel && !pe.DryRun {
 		return nil, fmt.Sprintf("* Bot does not have sufficient power level in [%s](%s) (have %d, minimum %d)", roomID, roomID.URI().MatrixToURL(), ownLevel, minLevel)
 	}
-	members, err := pe.Bot.Members(ctx, roomID)
+	members, err = pe.Bot.Members(ctx, roomID)
 	if err != nil {
 		return nil, fmt.Sprintf("* Failed to get room members for [%s](%s): %v", roomID, roomID.URI().MatrixToURL(), err)
 	}
@@ -106,12 +111,19 @@ func (pe *PolicyEvaluator) handleProtectedRooms(ctx context.Context, evt *event.
 			output = append(output, fmt.Sprintf("* Stopped protecting room [%s](%s)", roomID, roomID.URI().MatrixToURL()))
 		}
 	}
+	for roomID := range pe.wantToProtect {
+		if !slices.Contains(content.Rooms, roomID) {
+			delete(pe.wantToProtect, roomID)
+			pe.claimProtected(roomID, pe, false)
+		}
+	}
 	pe.protectedRoomsLock.Unlock()
 	joinedRooms, err := pe.Bot.JoinedRooms(ctx)
 	if err != nil {
 		return output, []string{"* Failed to get joined rooms: ", err.Error()}
 	}
 	var outLock sync.Mutex
+	var newlyProtected []id.RoomID
 	reevalMembers := make(map[id.UserID]struct{})
 	var wg sync.WaitGroup
 	for _, roomID := range content.Rooms {
@@ -127,7 +139,8 @@ func (pe *PolicyEvaluator) handleProtectedRooms(ctx context.Context, evt *event.
 			if errMsg != "" {
 				errors = append(errors, errMsg)
 			}
-			if !isInitial {
+			if !isInitial && errMsg == "" {
+				newlyProtected = append(newlyProtected, roomID)
 				for _, member := range members.Chunk {
 					reevalMembers[id.UserID(member.GetStateKey())] = struct{}{}
 				}
@@ -139,13 +152,27 @@ func (pe *PolicyEvaluator) handleProtectedRooms(ctx context.Context, evt *event.
 	if len(reevalMembers) > 0 {
 		pe.EvaluateAllMembers(ctx, slices.Collect(maps.Keys(reevalMembers)))
 	}
+	if !isInitial && len(newlyProtected) > 0 {
+		go pe.updateACLInRooms(context.WithoutCancel(ctx), newlyProtected)
+	}
 	return
 }
 
 func (pe *PolicyEvaluator) markAsWantToProtect(roomID id.RoomID) {
 	pe.protectedRoomsLock.Lock()
 	defer pe.protectedRoomsLock.Unlock()
-	pe.wantToProtect[roomID] = struct{}{}
+	if _, alreadyWanted := pe.wantToProtect[roomID]; !alreadyWanted {
+		pe.wantToProtect[roomID] = ""
+	}
+}
+
+// setProtectFailure stores the reason why protecting a room failed, so that it can be shown in !status.
+func (pe *PolicyEvaluator) setProtectFailure(roomID id.RoomID, reason string) {
+	pe.protectedRoomsLock.Lock()
+	defer pe.protectedRoomsLock.Unlock()
+	if _, wanted := pe.wantToProtect[roomID]; wanted {
+		pe.wantToProtect[roomID] = reason
+	}
 }
 
 func (pe *PolicyEvaluator) markAsProtectedRoom(roomID id.RoomID, evts []*event.Event) {
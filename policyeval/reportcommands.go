@@ -0,0 +1,141 @@
+package policyeval
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.mau.fi/meowlnir/database"
+)
+
+const reportListCaseLimit = 25
+
+func init() {
+	registerCommands(
+		&CommandHandler{
+			Name:        "reports",
+			Usage:       "[open|actioned|dismissed|all]",
+			Description: "List reports grouped by the reported user. Only open reports are shown by default.",
+			Func:        (*PolicyEvaluator).cmdReports,
+		},
+		&CommandHandler{
+			Name:        "report",
+			Description: "Triage received reports.",
+			Subcommands: []*CommandHandler{{
+				Name:        "resolve",
+				Usage:       "<report ID>",
+				Description: "Mark the report and all other open reports against the same user as actioned.",
+				MinArgs:     1,
+				Func:        (*PolicyEvaluator).cmdReportResolve,
+			}, {
+				Name:        "dismiss",
+				Usage:       "<report ID>",
+				Description: "Dismiss the report and all other open reports against the same user.",
+				MinArgs:     1,
+				Func:        (*PolicyEvaluator).cmdReportDismiss,
+			}},
+		},
+	)
+}
+
+func (pe *PolicyEvaluator) cmdReports(ctx context.Context, ce *CommandEvent) {
+	filter := &database.ReportFilter{Status: database.ReportStatusOpen, Limit: reportListCaseLimit + 1}
+	if len(ce.Args) > 0 {
+		switch status := database.ReportStatus(strings.ToLower(ce.Args[0])); status {
+		case database.ReportStatusOpen, database.ReportStatusActioned, database.ReportStatusDismissed:
+			filter.Status = status
+		case "all":
+			filter.Status = ""
+		default:
+			ce.ReplyUsage(ctx)
+			return
+		}
+	}
+	reports, err := pe.DB.Report.GetFiltered(ctx, pe.ManagementRoom, filter)
+	if err != nil {
+		ce.ReplyError(ctx, "Failed to get reports: %v", err)
+		return
+	}
+	statusName := string(filter.Status)
+	if statusName == "" {
+		statusName = "all"
+	}
+	if len(reports) == 0 {
+		ce.Reply(ctx, "No reports found (%s)", statusName)
+		return
+	}
+	cases := database.GroupReports(reports)
+	hasMore := len(cases) > reportListCaseLimit
+	if hasMore {
+		cases = cases[:reportListCaseLimit]
+	}
+	var reportCount int
+	for _, rc := range cases {
+		reportCount += len(rc.Reports)
+	}
+	var out strings.Builder
+	fmt.Fprintf(&out, "**Reports** (%s): %d reports in %d cases\n\n", statusName, reportCount, len(cases))
+	for _, rc := range cases {
+		out.WriteString(formatReportCase(rc))
+	}
+	if hasMore {
+		out.WriteString("\n...and more cases")
+	}
+	ce.Reply(ctx, strings.TrimSpace(out.String()))
+}
+
+func formatReportCase(rc *database.ReportCase) string {
+	latest := rc.Reports[0]
+	var target string
+	if rc.TargetUser != "" {
+		target = fmt.Sprintf("[%s](%s)", rc.TargetUser, rc.TargetUser.URI().MatrixToURL())
+	} else if latest.Type() == database.ReportTypeRoom {
+		target = fmt.Sprintf("Room [%s](%s)", latest.RoomID, latest.RoomID.URI().MatrixToURL())
+	} else {
+		target = "Unknown sender"
+	}
+	var count string
+	if len(rc.Reports) > 1 {
+		count = fmt.Sprintf("%d reports, latest ", len(rc.Reports))
+	}
+	status := string(latest.Status)
+	if latest.ResolvedBy != "" {
+		status = fmt.Sprintf("%s by [%s](%s)", latest.Status, latest.ResolvedBy, latest.ResolvedBy.URI().MatrixToURL())
+	}
+	return fmt.Sprintf(
+		"* %s: %s`%s` of %s by [%s](%s) at %s for %s (%s)\n",
+		target, count, latest.ID, formatReportedObject(latest),
+		latest.Reporter, latest.Reporter.URI().MatrixToURL(), formatTime(latest.CreatedAt), latest.Reason, status,
+	)
+}
+
+func (pe *PolicyEvaluator) cmdReportResolve(ctx context.Context, ce *CommandEvent) {
+	pe.resolveReportFromCommand(ctx, ce, database.ReportStatusActioned)
+}
+
+func (pe *PolicyEvaluator) cmdReportDismiss(ctx context.Context, ce *CommandEvent) {
+	pe.resolveReportFromCommand(ctx, ce, database.ReportStatusDismissed)
+}
+
+func (pe *PolicyEvaluator) resolveReportFromCommand(ctx context.Context, ce *CommandEvent, status database.ReportStatus) {
+	report, err := pe.DB.Report.GetByID(ctx, pe.ManagementRoom, ce.Args[0])
+	if err != nil {
+		ce.ReplyError(ctx, "Failed to get report: %v", err)
+		return
+	} else if report == nil {
+		ce.ReplyError(ctx, "Report `%s` not found", ce.Args[0])
+		return
+	} else if report.Status != database.ReportStatusOpen {
+		ce.ReplyError(ctx, "Report `%s` is already %s", report.ID, report.Status)
+		return
+	}
+	count, err := pe.DB.Report.Resolve(ctx, report, status, ce.Event.Sender)
+	if err != nil {
+		ce.ReplyError(ctx, "Failed to update report: %v", err)
+		return
+	}
+	if count > 1 {
+		ce.Reply(ctx, "Marked %d reports against [%s](%s) as %s", count, report.TargetUser, report.TargetUser.URI().MatrixToURL(), status)
+	}
+	ce.React(ctx)
+}
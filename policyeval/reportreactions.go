@@ -0,0 +1,131 @@
+package policyeval
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix/event"
+
+	"go.mau.fi/meowlnir/bot"
+	"go.mau.fi/meowlnir/database"
+	"go.mau.fi/meowlnir/policylist"
+)
+
+var errUnknownReportTarget = errors.New("the reported user is not known")
+
+type reportReactionAction int
+
+const (
+	reportReactionNone reportReactionAction = iota
+	reportReactionBan
+	reportReactionRedact
+	reportReactionDismiss
+)
+
+// normalizeReaction removes emoji variation selectors, as clients aren't consistent about including them.
+func normalizeReaction(key string) string {
+	return strings.ReplaceAll(key, "\ufe0f", "")
+}
+
+func (pe *PolicyEvaluator) getReportReactionAction(key string) reportReactionAction {
+	key = normalizeReaction(key)
+	switch {
+	case key == "":
+		return reportReactionNone
+	case key == normalizeReaction(pe.reportReactions.Ban) && pe.reportReactions.BanList != "":
+		return reportReactionBan
+	case key == normalizeReaction(pe.reportReactions.Redact):
+		return reportReactionRedact
+	case key == normalizeReaction(pe.reportReactions.Dismiss):
+		return reportReactionDismiss
+	default:
+		return reportReactionNone
+	}
+}
+
+// HandleReaction handles admin reactions to report notices in the management room.
+func (pe *PolicyEvaluator) HandleReaction(ctx context.Context, evt *event.Event) {
+	content, ok := evt.Content.Parsed.(*event.ReactionEventContent)
+	if !ok || !pe.Admins.Has(evt.Sender) {
+		return
+	}
+	action := pe.getReportReactionAction(content.RelatesTo.Key)
+	if action == reportReactionNone {
+		return
+	}
+	log := zerolog.Ctx(ctx).With().
+		Stringer("notice_event_id", content.RelatesTo.EventID).
+		Str("reaction", content.RelatesTo.Key).
+		Logger()
+	report, err := pe.DB.Report.GetByNotice(ctx, pe.ManagementRoom, content.RelatesTo.EventID)
+	if err != nil {
+		log.Err(err).Msg("Failed to get report for reaction")
+		return
+	} else if report == nil {
+		return
+	} else if report.Status != database.ReportStatusOpen {
+		log.Debug().Str("report_id", report.ID).Msg("Ignoring reaction to report that is already resolved")
+		return
+	}
+	log.Info().Str("report_id", report.ID).Msg("Handling reaction to report notice")
+	var result string
+	status := database.ReportStatusActioned
+	switch action {
+	case reportReactionBan:
+		result, err = pe.banReportedUser(ctx, report)
+	case reportReactionRedact:
+		if report.TargetUser == "" {
+			err = errUnknownReportTarget
+		} else {
+			pe.RedactUser(ctx, report.TargetUser, pe.reportReactions.BanReason, false)
+			result = "redacted messages"
+		}
+	case reportReactionDismiss:
+		status = database.ReportStatusDismissed
+		result = "dismissed"
+	}
+	if err != nil {
+		pe.Bot.SendNoticeOpts(ctx, pe.ManagementRoom, fmt.Sprintf("Failed to handle reaction: %v", err), &bot.SendNoticeOpts{
+			RelatesTo: (&event.RelatesTo{}).SetThread(report.NoticeEventID, report.NoticeEventID),
+		})
+		return
+	}
+	_, err = pe.DB.Report.Resolve(ctx, report, status, evt.Sender)
+	if err != nil {
+		log.Err(err).Msg("Failed to mark report as resolved")
+	}
+	pe.Bot.SendNoticeOpts(ctx, pe.ManagementRoom, fmt.Sprintf(
+		"%s\n\n%s %s by [%s](%s)",
+		formatReportNotice(report), content.RelatesTo.Key, result, evt.Sender, evt.Sender.URI().MatrixToURL(),
+	), &bot.SendNoticeOpts{Edit: report.NoticeEventID})
+}
+
+func (pe *PolicyEvaluator) banReportedUser(ctx context.Context, report *database.Report) (string, error) {
+	if report.TargetUser == "" {
+		return "", errUnknownReportTarget
+	} else if err := pe.checkCanBan(policylist.EntityTypeUser, string(report.TargetUser), event.PolicyRecommendationBan); err != nil {
+		return "", err
+	}
+	list := pe.FindListByShortcode(pe.reportReactions.BanList)
+	if list == nil {
+		return "", fmt.Errorf("list %q not found", pe.reportReactions.BanList)
+	}
+	policy := &event.ModPolicyContent{
+		Entity:         string(report.TargetUser),
+		Reason:         pe.reportReactions.BanReason,
+		Recommendation: event.PolicyRecommendationBan,
+	}
+	resp, err := pe.SendPolicy(ctx, list.RoomID, policylist.EntityTypeUser, "", policy, 0)
+	if err != nil {
+		return "", fmt.Errorf("failed to send ban policy: %w", err)
+	}
+	zerolog.Ctx(ctx).Info().
+		Stringer("policy_list", list.RoomID).
+		Any("policy", policy).
+		Stringer("policy_event_id", resp.EventID).
+		Msg("Sent ban policy from report reaction")
+	return fmt.Sprintf("banned in %s", list.Name), nil
+}
@@ -0,0 +1,210 @@
+package policyeval
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/meowlnir/database"
+	"go.mau.fi/meowlnir/policylist"
+)
+
+var (
+	errReportNoTargetUser = mautrix.RespError{
+		ErrCode:    "FI.MAU.MEOWLNIR.NO_TARGET_USER",
+		Err:        "This command requires a report of an event or user",
+		StatusCode: http.StatusBadRequest,
+	}
+	errRoomNotProtected = mautrix.RespError{
+		ErrCode:    "FI.MAU.MEOWLNIR.ROOM_NOT_PROTECTED",
+		StatusCode: http.StatusBadRequest,
+	}
+	errUserNotInRoom = mautrix.RespError{
+		ErrCode:    "FI.MAU.MEOWLNIR.NOT_IN_ROOM",
+		StatusCode: http.StatusNotFound,
+	}
+	errNotMonitoringList = mautrix.RespError{
+		ErrCode:    "FI.MAU.MEOWLNIR.NOT_MONITORING_LIST",
+		StatusCode: http.StatusBadRequest,
+	}
+	errListNotApplied = mautrix.RespError{
+		ErrCode:    "FI.MAU.MEOWLNIR.LIST_NOT_APPLIED",
+		StatusCode: http.StatusBadRequest,
+	}
+	errAlreadyWatched = mautrix.RespError{
+		ErrCode:    "FI.MAU.MEOWLNIR.ALREADY_WATCHED",
+		StatusCode: http.StatusConflict,
+	}
+)
+
+// handleReportCommand runs the slash command in the reason of a report sent by an admin.
+// If the command succeeds, the report is stored as actioned along with any open reports against the same target.
+func (pe *PolicyEvaluator) handleReportCommand(ctx context.Context, report *database.Report) error {
+	err := pe.runReportCommand(ctx, report)
+	if err != nil {
+		return err
+	}
+	log := zerolog.Ctx(ctx)
+	if err = pe.DB.Report.Insert(ctx, report); err != nil {
+		log.Err(err).Msg("Failed to save report command to database")
+	} else if _, err = pe.DB.Report.Resolve(ctx, report, database.ReportStatusActioned, report.Reporter); err != nil {
+		log.Err(err).Msg("Failed to mark reports as actioned after report command")
+	}
+	return nil
+}
+
+func (pe *PolicyEvaluator) runReportCommand(ctx context.Context, report *database.Report) error {
+	fields := strings.Fields(report.Reason)
+	cmd := strings.TrimPrefix(fields[0], "/")
+	args := fields[1:]
+	entityType, entity := policylist.EntityTypeUser, string(report.TargetUser)
+	if report.Type() == database.ReportTypeRoom {
+		entityType, entity = policylist.EntityTypeRoom, string(report.RoomID)
+	}
+	switch strings.ToLower(cmd) {
+	case "ban":
+		return pe.handleReportPolicy(ctx, report.Reporter, entityType, entity, event.PolicyRecommendationBan, args)
+	case "takedown":
+		return pe.handleReportPolicy(ctx, report.Reporter, entityType, entity, policylist.PolicyRecommendationTakedown, args)
+	case "watch":
+		return pe.handleReportWatch(ctx, report.Reporter, entityType, entity, args)
+	case "redact":
+		return pe.handleReportRedact(ctx, report, strings.Join(args, " "))
+	case "kick":
+		return pe.handleReportKick(ctx, report, strings.Join(args, " "))
+	default:
+		return mautrix.MUnrecognized.WithMessage("Unknown report command /%s", cmd)
+	}
+}
+
+func entityURL(entityType policylist.EntityType, entity string) string {
+	if entityType == policylist.EntityTypeRoom {
+		return id.RoomID(entity).URI().MatrixToURL()
+	}
+	return id.UserID(entity).URI().MatrixToURL()
+}
+
+// handleReportPolicy handles the `/ban` and `/takedown` report commands, which have the syntax
+// `<shortcode> [duration] <reason>`, by sending a policy with the given recommendation for the entity.
+func (pe *PolicyEvaluator) handleReportPolicy(ctx context.Context, sender id.UserID, entityType policylist.EntityType, entity string, recommendation event.PolicyRecommendation, args []string) error {
+	if entity == "" {
+		return errReportNoTargetUser
+	} else if len(args) < 2 {
+		return mautrix.MInvalidParam.WithMessage("Not enough arguments for %s", changeActionString(recommendation))
+	}
+	reasonArgs := args[1:]
+	expiry, hasExpiry, err := parseDuration(reasonArgs[0])
+	if err != nil {
+		return mautrix.MInvalidParam.WithMessage("Invalid expiry %s: %v", reasonArgs[0], err)
+	} else if hasExpiry {
+		reasonArgs = reasonArgs[1:]
+	}
+	if err := pe.checkCanBan(entityType, entity, recommendation); err != nil {
+		return err
+	}
+	list := pe.FindListByShortcode(args[0])
+	if list == nil {
+		pe.sendNotice(ctx, `Failed to handle [%s](%s)'s report of [%s](%s): list %q not found`,
+			sender, sender.URI().MatrixToURL(), entity, entityURL(entityType, entity), args[0])
+		return mautrix.MNotFound.WithMessage(fmt.Sprintf("List with shortcode %q not found", args[0]))
+	} else if list.DontApply && recommendation == policylist.PolicyRecommendationTakedown {
+		// Takedowns are expected to ban and redact immediately, which doesn't happen with lists that aren't applied
+		return errListNotApplied.WithMessage("%s is not applied, so a takedown wouldn't have any effect", list.Name)
+	}
+	policy := &event.ModPolicyContent{
+		Entity:         entity,
+		Reason:         strings.Join(reasonArgs, " "),
+		Recommendation: recommendation,
+	}
+	return pe.sendReportPolicy(ctx, sender, entityType, list.RoomID, list.Name, policy, expiry)
+}
+
+// handleReportWatch handles the `/watch <shortcode> [reason]` report command, which adds the entity
+// to a list that is watched but not applied, so that it shows up in !match and !whois without being banned.
+func (pe *PolicyEvaluator) handleReportWatch(ctx context.Context, sender id.UserID, entityType policylist.EntityType, entity string, args []string) error {
+	if entity == "" {
+		return errReportNoTargetUser
+	} else if len(args) < 1 {
+		return mautrix.MInvalidParam.WithMessage("Not enough arguments for watch")
+	}
+	list := pe.FindListByShortcode(args[0])
+	if list == nil {
+		return mautrix.MNotFound.WithMessage(fmt.Sprintf("List with shortcode %q not found", args[0]))
+	} else if !list.DontApply {
+		return errNotMonitoringList.WithMessage("%s is applied, use /ban to ban users or a list with dont_apply to watch them", list.Name)
+	}
+	if existing := pe.matchEntity(entityType, entity, []id.RoomID{list.RoomID}); len(existing) > 0 {
+		return errAlreadyWatched.WithMessage("%s is already in %s for: %s", entity, list.Name, existing[0].Reason)
+	}
+	policy := &event.ModPolicyContent{
+		Entity:         entity,
+		Reason:         strings.Join(args[1:], " "),
+		Recommendation: event.PolicyRecommendationBan,
+	}
+	return pe.sendReportPolicy(ctx, sender, entityType, list.RoomID, list.Name, policy, 0)
+}
+
+func (pe *PolicyEvaluator) sendReportPolicy(ctx context.Context, sender id.UserID, entityType policylist.EntityType, listID id.RoomID, listName string, policy *event.ModPolicyContent, expiry time.Duration) error {
+	resp, err := pe.SendPolicy(ctx, listID, entityType, "", policy, expiry)
+	if err != nil {
+		pe.sendNotice(ctx, `Failed to handle [%s](%s)'s report of [%s](%s) for %s ([%s](%s)): %v`,
+			sender, sender.URI().MatrixToURL(), policy.Entity, entityURL(entityType, policy.Entity),
+			listName, listID, listID.URI().MatrixToURL(), err)
+		return fmt.Errorf("failed to send policy: %w", err)
+	}
+	zerolog.Ctx(ctx).Info().
+		Stringer("policy_list", listID).
+		Any("policy", policy).
+		Stringer("policy_event_id", resp.EventID).
+		Msg("Sent policy from report")
+	pe.sendNotice(ctx, `Processed [%s](%s)'s report of [%s](%s) and sent a %s policy to %s ([%s](%s)) for %s`,
+		sender, sender.URI().MatrixToURL(), policy.Entity, entityURL(entityType, policy.Entity),
+		changeActionString(policy.Recommendation), listName, listID, listID.URI().MatrixToURL(), policy.Reason)
+	return nil
+}
+
+// handleReportRedact handles the `/redact [reason]` report command by redacting all events of the reported user.
+func (pe *PolicyEvaluator) handleReportRedact(ctx context.Context, report *database.Report, reason string) error {
+	if report.TargetUser == "" {
+		return errReportNoTargetUser
+	}
+	zerolog.Ctx(ctx).Info().
+		Stringer("user_id", report.TargetUser).
+		Msg("Redacting user's events from report")
+	pe.RedactUser(ctx, report.TargetUser, reason, false)
+	return nil
+}
+
+// handleReportKick handles the `/kick [reason]` report command by kicking the reported user from the room
+// the reported event is in. For user reports, the user is kicked from all protected rooms.
+func (pe *PolicyEvaluator) handleReportKick(ctx context.Context, report *database.Report, reason string) error {
+	userID := report.TargetUser
+	if userID == "" {
+		return errReportNoTargetUser
+	}
+	rooms := pe.getRoomsUserIsIn(userID)
+	if report.Type() == database.ReportTypeEvent {
+		if !pe.IsProtectedRoom(report.RoomID) {
+			return errRoomNotProtected.WithMessage("%s is not a protected room", report.RoomID)
+		} else if !slices.Contains(rooms, report.RoomID) {
+			return errUserNotInRoom.WithMessage("%s is not in %s", userID, report.RoomID)
+		}
+		rooms = []id.RoomID{report.RoomID}
+	} else if len(rooms) == 0 {
+		return errUserNotInRoom.WithMessage("%s is not in any protected rooms", userID)
+	}
+	for _, roomID := range rooms {
+		if !pe.KickUser(ctx, userID, roomID, reason) {
+			return mautrix.MUnknown.WithMessage("Failed to queue kick in %s", roomID)
+		}
+	}
+	return nil
+}
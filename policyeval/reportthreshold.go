@@ -0,0 +1,166 @@
+package policyeval
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.mau.fi/util/exfmt"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/meowlnir/database"
+	"go.mau.fi/meowlnir/policylist"
+)
+
+type reportTargetLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lockReportTarget locks the report threshold check for the given user, so that concurrent reports
+// don't both reach the threshold and act twice. The returned function must be called to unlock.
+func (pe *PolicyEvaluator) lockReportTarget(target id.UserID) func() {
+	pe.reportTargetLocksLock.Lock()
+	lock, ok := pe.reportTargetLocks[target]
+	if !ok {
+		lock = &reportTargetLock{}
+		pe.reportTargetLocks[target] = lock
+	}
+	lock.refs++
+	pe.reportTargetLocksLock.Unlock()
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		pe.reportTargetLocksLock.Lock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(pe.reportTargetLocks, target)
+		}
+		pe.reportTargetLocksLock.Unlock()
+	}
+}
+
+// checkReportThreshold checks if the given user has been reported by enough distinct room members within the
+// configured window. If so, the reported events are redacted and the user is optionally temporarily banned
+// from the rooms where the events were sent.
+func (pe *PolicyEvaluator) checkReportThreshold(ctx context.Context, target id.UserID) {
+	threshold := pe.reportThreshold
+	if threshold.Reporters <= 0 || target == "" || target == pe.Bot.UserID || pe.Admins.Has(target) {
+		return
+	}
+	unlock := pe.lockReportTarget(target)
+	defer unlock()
+	log := zerolog.Ctx(ctx).With().Stringer("target_user_id", target).Logger()
+	reports, err := pe.DB.Report.GetOpenByTargetSince(ctx, pe.ManagementRoom, target, time.Now().Add(-threshold.Window))
+	if err != nil {
+		log.Err(err).Msg("Failed to get recent reports to check threshold")
+		return
+	}
+	reporters := make(map[id.UserID]struct{})
+	var counted []*database.Report
+	pe.protectedRoomsLock.RLock()
+	for _, report := range reports {
+		// Only members of the room count, so that random users can't get events redacted
+		if pe.Admins.Has(report.Reporter) || !slices.Contains(pe.protectedRoomMembers[report.Reporter], report.RoomID) {
+			continue
+		}
+		reporters[report.Reporter] = struct{}{}
+		counted = append(counted, report)
+	}
+	pe.protectedRoomsLock.RUnlock()
+	if len(reporters) < threshold.Reporters {
+		return
+	}
+	log.Info().
+		Int("reporter_count", len(reporters)).
+		Int("report_count", len(counted)).
+		Msg("Report threshold reached, taking automatic action")
+	reason := fmt.Sprintf("reported by %d users", len(reporters))
+	eventsByRoom := make(map[id.RoomID][]id.EventID)
+	for _, report := range counted {
+		if !slices.Contains(eventsByRoom[report.RoomID], report.EventID) {
+			eventsByRoom[report.RoomID] = append(eventsByRoom[report.RoomID], report.EventID)
+		}
+	}
+	var out strings.Builder
+	fmt.Fprintf(
+		&out, "Report threshold reached for [%s](%s): %d users reported their events within %s\n\n",
+		target, target.URI().MatrixToURL(), len(reporters), exfmt.Duration(threshold.Window),
+	)
+	for _, report := range counted {
+		fmt.Fprintf(
+			&out, "* [%s](%s) reported [an event](%s) at %s for %s (report `%s`)\n",
+			report.Reporter, report.Reporter.URI().MatrixToURL(), report.RoomID.EventURI(report.EventID).MatrixToURL(),
+			formatTime(report.CreatedAt), report.Reason, report.ID,
+		)
+	}
+	out.WriteString("\n")
+	for roomID, eventIDs := range eventsByRoom {
+		roomLink := fmt.Sprintf("[%s](%s)", roomID, roomID.URI().MatrixToURL())
+		err = pe.queueAction(ctx, roomID, database.QueuedActionTypeRedact, &database.QueuedActionPayload{
+			UserID:   target,
+			Reason:   reason,
+			EventIDs: eventIDs,
+		})
+		if err != nil {
+			fmt.Fprintf(&out, "* Failed to queue redactions in %s: %v\n", roomLink, err)
+			continue
+		}
+		fmt.Fprintf(&out, "* Queued redaction of %s in %s\n", pluralize(len(eventIDs), "event"), roomLink)
+		if threshold.TempBan > 0 {
+			fmt.Fprintf(&out, "* %s\n", pe.tempBanUser(ctx, target, roomID, reason, threshold.TempBan))
+		}
+	}
+	pe.sendNotice(ctx, strings.TrimSpace(out.String()))
+	reportIDs := make([]string, len(counted))
+	for i, report := range counted {
+		reportIDs[i] = report.ID
+	}
+	_, err = pe.DB.Report.ResolveMany(ctx, pe.ManagementRoom, reportIDs, database.ReportStatusActioned, pe.Bot.UserID)
+	if err != nil {
+		log.Err(err).Msg("Failed to mark reports as actioned")
+	}
+}
+
+// tempBanUser queues a ban for the user in the given room, as well as an unban after the given duration.
+// The returned string describes the result for the management room notice.
+func (pe *PolicyEvaluator) tempBanUser(ctx context.Context, userID id.UserID, roomID id.RoomID, reason string, duration time.Duration) string {
+	roomLink := fmt.Sprintf("[%s](%s)", roomID, roomID.URI().MatrixToURL())
+	err := pe.queueAction(ctx, roomID, database.QueuedActionTypeBan, &database.QueuedActionPayload{
+		UserID: userID,
+		Reason: reason,
+	})
+	if err != nil {
+		return fmt.Sprintf("Failed to queue temporary ban in %s: %v", roomLink, err)
+	}
+	err = pe.queueActionAt(ctx, roomID, database.QueuedActionTypeTempUnban, &database.QueuedActionPayload{
+		UserID: userID,
+		Reason: "temporary ban expired",
+	}, time.Now().Add(duration))
+	if err != nil {
+		return fmt.Sprintf("Queued ban in %s, but failed to schedule unban: %v", roomLink, err)
+	}
+	return fmt.Sprintf("Queued temporary ban in %s for %s", roomLink, exfmt.Duration(duration))
+}
+
+// liftTempBan unbans a temporarily banned user, unless they've been banned by a policy in the meantime.
+//
+// The current policies are checked rather than the actions taken by Meowlnir, as the latter aren't removed
+// if a ban is lifted manually.
+func (pe *PolicyEvaluator) liftTempBan(ctx context.Context, qa *database.QueuedAction) error {
+	rec := pe.matchUser(qa.Payload.UserID).Recommendations().BanOrUnban
+	qa.Payload.Superseded = rec != nil && policylist.IsBanRecommendation(rec.Recommendation)
+	if qa.Payload.Superseded || pe.DryRun {
+		return nil
+	}
+	_, err := pe.Bot.UnbanUser(ctx, qa.RoomID, &mautrix.ReqUnbanUser{
+		Reason: qa.Payload.Reason,
+		UserID: qa.Payload.UserID,
+	})
+	return err
+}
@@ -0,0 +1,290 @@
+package policyeval
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.mau.fi/util/glob"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+	"maunium.net/go/mautrix/synapseadmin"
+
+	"go.mau.fi/meowlnir/config"
+	"go.mau.fi/meowlnir/database"
+	"go.mau.fi/meowlnir/policylist"
+)
+
+// MatchRoomBan returns the policy that bans the given room, or nil if the room isn't banned by any applied list.
+func (pe *PolicyEvaluator) MatchRoomBan(roomID id.RoomID) *policylist.Policy {
+	rec := pe.Store.MatchRoom(pe.GetWatchedLists(), roomID).Recommendations().BanOrUnban
+	if rec == nil || !policylist.IsBanRecommendation(rec.Recommendation) {
+		return nil
+	}
+	return rec
+}
+
+// IsUsingRoom returns true if the given room is the management room, a watched policy list
+// or a protected room of this evaluator.
+func (pe *PolicyEvaluator) IsUsingRoom(roomID id.RoomID) bool {
+	if roomID == pe.ManagementRoom || pe.IsWatchingList(roomID) {
+		return true
+	}
+	pe.protectedRoomsLock.RLock()
+	_, isProtecting := pe.protectedRooms[roomID]
+	_, wantToProtect := pe.wantToProtect[roomID]
+	pe.protectedRoomsLock.RUnlock()
+	return isProtecting || wantToProtect
+}
+
+// canLeaveRoom returns false for rooms that the bot must never leave automatically, even if they're banned.
+// The bot may have multiple management rooms, so the rooms used by all of them are checked.
+func (pe *PolicyEvaluator) canLeaveRoom(roomID id.RoomID) bool {
+	return !pe.IsUsingRoom(roomID) && !pe.isRoomUsedByBot(pe.Bot.UserID, roomID)
+}
+
+// HandleBotInvite checks if the room the bot was invited to is banned, and rejects the invite if it is.
+//
+// The return value is true if the invite was rejected and shouldn't be processed further.
+func (pe *PolicyEvaluator) HandleBotInvite(ctx context.Context, evt *event.Event) bool {
+	policy := pe.MatchRoomBan(evt.RoomID)
+	if policy == nil || !pe.canLeaveRoom(evt.RoomID) {
+		return false
+	}
+	log := zerolog.Ctx(ctx).With().
+		Stringer("room_id", evt.RoomID).
+		Stringer("inviter", evt.Sender).
+		Str("policy_entity", policy.EntityOrHash()).
+		Logger()
+	var err error
+	if !pe.DryRun {
+		_, err = pe.Bot.LeaveRoom(ctx, evt.RoomID, &mautrix.ReqLeave{Reason: "Room is banned"})
+	}
+	if err != nil {
+		log.Err(err).Msg("Failed to reject invite to banned room")
+		pe.sendNotice(ctx, "Failed to reject invite from [%s](%s) to banned room [%s](%s): %v",
+			evt.Sender, evt.Sender.URI().MatrixToURL(), evt.RoomID, evt.RoomID.URI().MatrixToURL(), err)
+	} else {
+		log.Info().Msg("Rejected invite to banned room")
+		pe.sendNotice(ctx, "Rejected invite from [%s](%s) to [%s](%s), which is banned by rule `%s` in %s for %s",
+			evt.Sender, evt.Sender.URI().MatrixToURL(), evt.RoomID, evt.RoomID.URI().MatrixToURL(),
+			policy.EntityOrHash(), pe.listName(policy.RoomID), policy.Reason)
+	}
+	return true
+}
+
+func (pe *PolicyEvaluator) listName(roomID id.RoomID) string {
+	meta := pe.GetWatchedListMeta(roomID)
+	if meta == nil {
+		return roomID.String()
+	}
+	return meta.Name
+}
+
+// EvaluateJoinedRooms checks all rooms the bot is in against room rules and leaves the banned ones.
+func (pe *PolicyEvaluator) EvaluateJoinedRooms(ctx context.Context) {
+	joinedRooms, err := pe.Bot.JoinedRooms(ctx)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to get joined rooms to evaluate room rules")
+		pe.sendNotice(ctx, "Failed to get joined rooms to evaluate room rules: %v", err)
+		return
+	}
+	for _, roomID := range joinedRooms.JoinedRooms {
+		if policy := pe.MatchRoomBan(roomID); policy != nil {
+			pe.ApplyRoomBan(ctx, roomID, policy, true)
+		}
+	}
+}
+
+// EvaluateAddedRoomRule applies a newly added room rule to the room it targets and any joined rooms that match it.
+func (pe *PolicyEvaluator) EvaluateAddedRoomRule(ctx context.Context, policy *policylist.Policy) {
+	if !policylist.IsBanRecommendation(policy.Recommendation) || policy.Ignored {
+		return
+	}
+	var targets []id.RoomID
+	if _, isExact := policy.Pattern.(glob.ExactGlob); isExact {
+		targets = append(targets, id.RoomID(policy.Entity))
+	}
+	joinedRooms, err := pe.Bot.JoinedRooms(ctx)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to get joined rooms to evaluate room rule")
+	} else {
+		for _, roomID := range joinedRooms.JoinedRooms {
+			if !slices.Contains(targets, roomID) && policy.Pattern.Match(string(roomID)) {
+				targets = append(targets, roomID)
+			}
+		}
+	}
+	for _, roomID := range targets {
+		// Do a full evaluation to ensure new policies don't bypass existing unban policies
+		if rec := pe.MatchRoomBan(roomID); rec != nil {
+			isJoined := joinedRooms != nil && slices.Contains(joinedRooms.JoinedRooms, roomID)
+			pe.ApplyRoomBan(ctx, roomID, rec, isJoined)
+		}
+	}
+}
+
+// ApplyRoomBan leaves the given banned room and applies the room ban action configured for the policy list.
+func (pe *PolicyEvaluator) ApplyRoomBan(ctx context.Context, roomID id.RoomID, policy *policylist.Policy, isJoined bool) {
+	log := zerolog.Ctx(ctx).With().
+		Stringer("room_id", roomID).
+		Stringer("policy_list_id", policy.RoomID).
+		Str("policy_entity", policy.EntityOrHash()).
+		Logger()
+	if !pe.canLeaveRoom(roomID) {
+		log.Warn().Msg("Not applying ban to room used by this bot")
+		pe.sendNotice(ctx, "⚠️ [%s](%s) is banned by rule `%s` in %s, but it's a management, protected or policy list room, so it won't be touched",
+			roomID, roomID.URI().MatrixToURL(), policy.EntityOrHash(), pe.listName(policy.RoomID))
+		return
+	}
+	if isJoined {
+		var err error
+		if !pe.DryRun {
+			_, err = pe.Bot.LeaveRoom(ctx, roomID, &mautrix.ReqLeave{Reason: "Room is banned"})
+		}
+		if err != nil {
+			log.Err(err).Msg("Failed to leave banned room")
+			pe.sendNotice(ctx, "Failed to leave banned room [%s](%s): %v", roomID, roomID.URI().MatrixToURL(), err)
+		} else {
+			log.Info().Msg("Left banned room")
+			pe.sendNotice(ctx, "Left banned room [%s](%s)", roomID, roomID.URI().MatrixToURL())
+		}
+	}
+	action := config.RoomBanActionNone
+	if meta := pe.GetWatchedListMeta(policy.RoomID); meta != nil && meta.RoomBanAction != "" {
+		action = meta.RoomBanAction
+	}
+	if action == config.RoomBanActionNone {
+		return
+	}
+	localMembers, err := pe.getLocalRoomMembers(ctx, roomID)
+	if err != nil {
+		log.Debug().Err(err).Msg("Failed to get local members of banned room")
+		err = nil
+	}
+	if !pe.DryRun {
+		switch action {
+		case config.RoomBanActionPurge:
+			_, err = pe.Bot.SynapseAdmin.DeleteRoom(ctx, roomID, synapseadmin.ReqDeleteRoom{
+				Purge:   true,
+				Block:   true,
+				Message: fmt.Sprintf("This room has been banned: %s", policy.Reason),
+			})
+		default:
+			err = pe.Bot.SynapseAdmin.BlockRoom(ctx, roomID, synapseadmin.ReqBlockRoom{Block: true})
+		}
+	}
+	if err != nil {
+		var respErr mautrix.HTTPError
+		if errors.As(err, &respErr) {
+			err = respErr
+		}
+		log.Err(err).Str("room_ban_action", string(action)).Msg("Failed to apply room ban on homeserver")
+		pe.sendNotice(ctx, "Failed to %s banned room [%s](%s): %v", action, roomID, roomID.URI().MatrixToURL(), err)
+		return
+	}
+	log.Info().
+		Str("room_ban_action", string(action)).
+		Int("local_member_count", len(localMembers)).
+		Msg("Applied room ban on homeserver")
+	if action == config.RoomBanActionBlock {
+		// Purges can't be undone, but blocks are recorded so that they can be lifted if the policy is removed
+		err = pe.DB.TakenAction.Put(ctx, &database.TakenAction{
+			InRoomID:   roomID,
+			ActionType: database.TakenActionTypeRoomBlock,
+			PolicyList: policy.RoomID,
+			RuleEntity: policy.EntityOrHash(),
+			RuleType:   string(policy.EntityType),
+			Action:     policy.Recommendation,
+			TakenAt:    time.Now(),
+		})
+		if err != nil {
+			log.Err(err).Msg("Failed to save room block to database")
+		}
+	}
+	var verb string
+	switch action {
+	case config.RoomBanActionPurge:
+		verb = "Shut down and purged"
+	default:
+		verb = "Blocked"
+	}
+	output := fmt.Sprintf("%s [%s](%s) for %s", verb, roomID, roomID.URI().MatrixToURL(), policy.Reason)
+	if len(localMembers) > 0 {
+		formattedMembers := make([]string, len(localMembers))
+		for i, userID := range localMembers {
+			formattedMembers[i] = fmt.Sprintf("* [%s](%s)", userID, userID.URI().MatrixToURL())
+		}
+		output += fmt.Sprintf(". The room had %s on this server:\n\n%s",
+			pluralize(len(localMembers), "local member"), strings.Join(formattedMembers, "\n"))
+	}
+	pe.sendNotice(ctx, output)
+}
+
+// reevaluateRoomBlock checks whether a room blocked on the homeserver is still banned,
+// and unblocks it if the policy list that caused the block has auto-unban enabled.
+func (pe *PolicyEvaluator) reevaluateRoomBlock(ctx context.Context, action *database.TakenAction, listMeta map[id.RoomID]*config.WatchedPolicyList) {
+	roomID := action.InRoomID
+	log := zerolog.Ctx(ctx).With().Any("taken_action", action).Logger()
+	if rec := pe.MatchRoomBan(roomID); rec != nil {
+		if rec.RoomID != action.PolicyList || rec.EntityOrHash() != action.RuleEntity {
+			action.PolicyList = rec.RoomID
+			action.RuleEntity = rec.EntityOrHash()
+			action.RuleType = string(rec.EntityType)
+			err := pe.DB.TakenAction.Put(ctx, action)
+			if err != nil {
+				log.Err(err).Msg("Failed to update room block with new rule")
+			}
+		}
+		log.Debug().Str("new_rule_entity", rec.EntityOrHash()).Msg("Room block is still valid after re-evaluation")
+		return
+	}
+	meta := listMeta[action.PolicyList]
+	if meta == nil {
+		meta = pe.GetWatchedListMeta(action.PolicyList)
+	}
+	if meta == nil || !meta.AutoUnban {
+		log.Debug().Msg("Room block is no longer valid, but policy list doesn't have auto-unban enabled")
+		return
+	}
+	var err error
+	if !pe.DryRun {
+		err = pe.Bot.SynapseAdmin.BlockRoom(ctx, roomID, synapseadmin.ReqBlockRoom{Block: false})
+	}
+	if err != nil {
+		var respErr mautrix.HTTPError
+		if errors.As(err, &respErr) {
+			err = respErr
+		}
+		log.Err(err).Msg("Failed to unblock room on homeserver")
+		pe.sendNotice(ctx, "Failed to unblock [%s](%s): %v", roomID, roomID.URI().MatrixToURL(), err)
+		return
+	}
+	err = pe.DB.TakenAction.Delete(ctx, action)
+	if err != nil {
+		log.Err(err).Msg("Failed to delete room block from database")
+	}
+	log.Info().Msg("Unblocked room on homeserver")
+	pe.sendNotice(ctx, "Unblocked [%s](%s) as the ban policy for `%s` was removed from or expired in %s",
+		roomID, roomID.URI().MatrixToURL(), action.RuleEntity, meta.Name)
+}
+
+func (pe *PolicyEvaluator) getLocalRoomMembers(ctx context.Context, roomID id.RoomID) ([]id.UserID, error) {
+	resp, err := pe.Bot.SynapseAdmin.RoomMembers(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	ownServer := pe.Bot.UserID.Homeserver()
+	localMembers := make([]id.UserID, 0, len(resp.Members))
+	for _, userID := range resp.Members {
+		if userID.Homeserver() == ownServer && userID != pe.Bot.UserID {
+			localMembers = append(localMembers, userID)
+		}
+	}
+	return localMembers, nil
+}
@@ -0,0 +1,113 @@
+package policyeval
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.mau.fi/util/glob"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/meowlnir/database"
+)
+
+// CompileACL builds the list of denied servers from the server rules in all applied watched lists.
+func (pe *PolicyEvaluator) CompileACL() []string {
+	rules := pe.Store.ListServerRules(pe.GetWatchedLists())
+	ownServer := pe.Bot.UserID.Homeserver()
+	deny := make([]string, 0, len(rules))
+	for entity, policy := range rules {
+		if policy.Recommendation == event.PolicyRecommendationUnban {
+			continue
+		} else if policy.Pattern.Match(ownServer) {
+			// Never ban our own server, that would make the bot unable to do anything in the room
+			continue
+		}
+		deny = append(deny, entity)
+	}
+	slices.Sort(deny)
+	return deny
+}
+
+// DeferredUpdateACL schedules an ACL update in the background.
+//
+// Multiple calls within a short period of time are merged, so that mass changes to policy lists
+// don't cause a separate ACL update for every rule.
+func (pe *PolicyEvaluator) DeferredUpdateACL() {
+	select {
+	case pe.aclDeferChan <- struct{}{}:
+	default:
+	}
+}
+
+func (pe *PolicyEvaluator) aclDeferLoop() {
+	log := pe.Bot.Log.With().
+		Stringer("management_room", pe.ManagementRoom).
+		Str("action", "deferred acl update").
+		Logger()
+	ctx := log.WithContext(context.Background())
+	for range pe.aclDeferChan {
+		time.Sleep(5 * time.Second)
+		pe.UpdateACL(ctx)
+	}
+}
+
+// UpdateACL queues an update of the server ACL in all protected rooms.
+func (pe *PolicyEvaluator) UpdateACL(ctx context.Context) {
+	pe.updateACLInRooms(ctx, pe.GetProtectedRooms())
+}
+
+// updateACLInRooms queues an ACL update for each of the given rooms.
+// The ACL is compiled when the queued action is executed, so it's always up to date.
+func (pe *PolicyEvaluator) updateACLInRooms(ctx context.Context, rooms []id.RoomID) {
+	for _, roomID := range rooms {
+		_ = pe.queueAction(ctx, roomID, database.QueuedActionTypeServerACL, &database.QueuedActionPayload{})
+	}
+}
+
+func (pe *PolicyEvaluator) updateACLInRoom(ctx context.Context, roomID id.RoomID, deny []string) error {
+	log := zerolog.Ctx(ctx).With().Stringer("room_id", roomID).Logger()
+	var content event.ServerACLEventContent
+	err := pe.Bot.StateEvent(ctx, roomID, event.StateServerACL, "", &content)
+	if errors.Is(err, mautrix.MNotFound) {
+		// Rooms without an ACL event will return M_NOT_FOUND, which is fine, we'll just create a new one
+		log.Debug().Msg("Room doesn't have a server ACL, creating a new one")
+	} else if err != nil {
+		// Other errors must not be treated as an empty ACL, as that would overwrite the existing allow list
+		return fmt.Errorf("failed to get current server ACL: %w", err)
+	}
+	if slices.Equal(content.Deny, deny) {
+		log.Trace().Msg("Server ACL is already up to date")
+		return nil
+	}
+	if len(content.Allow) == 0 {
+		content.Allow = []string{"*"}
+	}
+	ownServer := pe.Bot.UserID.Homeserver()
+	if !slices.ContainsFunc(content.Allow, func(pattern string) bool {
+		return glob.Compile(pattern).Match(ownServer)
+	}) {
+		log.Warn().Strs("allow", content.Allow).Msg("Own server isn't allowed by room ACL, not updating it")
+		pe.sendNotice(ctx, "Own server is not in the allow list of the server ACL in [%s](%s), not updating it", roomID, roomID.URI().MatrixToURL())
+		return nil
+	}
+	content.Deny = deny
+	if pe.DryRun {
+		log.Info().Int("deny_count", len(deny)).Msg("Dry run: would have updated server ACL")
+		return nil
+	}
+	resp, err := pe.Bot.SendStateEvent(ctx, roomID, event.StateServerACL, "", &content)
+	if err != nil {
+		return err
+	}
+	log.Info().
+		Stringer("event_id", resp.EventID).
+		Int("deny_count", len(deny)).
+		Msg("Updated server ACL")
+	return nil
+}
@@ -0,0 +1,135 @@
+package policyeval
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/meowlnir/policylist"
+)
+
+func init() {
+	registerCommands(
+		&CommandHandler{
+			Name:        "status",
+			Description: "Show an overview of the watched lists, protected rooms and bot configuration.",
+			Func:        (*PolicyEvaluator).cmdStatus,
+		},
+		&CommandHandler{
+			Name:        "rooms",
+			Description: "List protected rooms with their member counts and the bot's power level in each.",
+			Func:        (*PolicyEvaluator).cmdRooms,
+		},
+	)
+}
+
+func (pe *PolicyEvaluator) cmdStatus(ctx context.Context, ce *CommandEvent) {
+	var out strings.Builder
+	dryRun := "disabled"
+	if pe.DryRun {
+		dryRun = "**enabled**"
+	}
+	fmt.Fprintf(&out, "* Dry run: %s\n", dryRun)
+	admins := pe.Admins.AsList()
+	slices.Sort(admins)
+	adminLinks := make([]string, len(admins))
+	for i, admin := range admins {
+		adminLinks[i] = fmt.Sprintf("[%s](%s)", admin, admin.URI().MatrixToURL())
+	}
+	fmt.Fprintf(&out, "* Admins: %s\n", strings.Join(adminLinks, ", "))
+
+	lists := pe.GetAllWatchedLists()
+	fmt.Fprintf(&out, "\n**Watched lists** (%d)\n\n", len(lists))
+	for _, list := range lists {
+		applied := "applied"
+		if list.DontApply {
+			applied = "not applied"
+		}
+		room := pe.Store.GetRoom(list.RoomID)
+		if room == nil {
+			fmt.Fprintf(&out, "* [%s](%s) (`%s`, %s) - not loaded\n", list.Name, list.RoomID.URI().MatrixToURL(), list.Shortcode, applied)
+			continue
+		}
+		fmt.Fprintf(
+			&out, "* [%s](%s) (`%s`, %s) - users: %s; rooms: %s; servers: %s\n",
+			list.Name, list.RoomID.URI().MatrixToURL(), list.Shortcode, applied,
+			countPolicies(room.UserRules), countPolicies(room.RoomRules), countPolicies(room.ServerRules),
+		)
+	}
+	out.WriteString("\n")
+	pe.writeProtectedRooms(ctx, &out)
+	ce.Reply(ctx, strings.TrimSpace(out.String()))
+}
+
+func (pe *PolicyEvaluator) cmdRooms(ctx context.Context, ce *CommandEvent) {
+	var out strings.Builder
+	pe.writeProtectedRooms(ctx, &out)
+	ce.Reply(ctx, strings.TrimSpace(out.String()))
+}
+
+// countPolicies formats the number of policies in the list grouped by recommendation.
+func countPolicies(list *policylist.List) string {
+	counts := make(map[event.PolicyRecommendation]int)
+	for _, policy := range list.All() {
+		counts[policy.Recommendation]++
+	}
+	if len(counts) == 0 {
+		return "none"
+	}
+	parts := make([]string, 0, len(counts))
+	for _, rec := range slices.Sorted(maps.Keys(counts)) {
+		parts = append(parts, fmt.Sprintf("%d `%s`", counts[rec], rec))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// writeProtectedRooms writes the list of protected rooms and rooms that failed to be protected into the given builder.
+func (pe *PolicyEvaluator) writeProtectedRooms(ctx context.Context, out *strings.Builder) {
+	pe.protectedRoomsLock.RLock()
+	memberCounts := make(map[id.RoomID]int, len(pe.protectedRooms))
+	for roomID := range pe.protectedRooms {
+		memberCounts[roomID] = 0
+	}
+	for _, memberRooms := range pe.protectedRoomMembers {
+		for _, roomID := range memberRooms {
+			if _, ok := memberCounts[roomID]; ok {
+				memberCounts[roomID]++
+			}
+		}
+	}
+	failed := maps.Clone(pe.wantToProtect)
+	pe.protectedRoomsLock.RUnlock()
+
+	fmt.Fprintf(out, "**Protected rooms** (%d)\n\n", len(memberCounts))
+	for _, roomID := range slices.Sorted(maps.Keys(memberCounts)) {
+		fmt.Fprintf(out, "* [%s](%s) - %d members", roomID, roomID.URI().MatrixToURL(), memberCounts[roomID])
+		var powerLevels event.PowerLevelsEventContent
+		err := pe.Bot.StateEvent(ctx, roomID, event.StatePowerLevels, "", &powerLevels)
+		if err != nil {
+			fmt.Fprintf(out, ", failed to get power levels: %v", err)
+		} else {
+			ownLevel := powerLevels.GetUserLevel(pe.Bot.UserID)
+			minLevel := max(powerLevels.Ban(), powerLevels.Redact())
+			fmt.Fprintf(out, ", power level %d", ownLevel)
+			if ownLevel < minLevel {
+				fmt.Fprintf(out, " (⚠️ minimum %d)", minLevel)
+			}
+		}
+		out.WriteString("\n")
+	}
+	if len(failed) > 0 {
+		fmt.Fprintf(out, "\n**Failed to protect** (%d)\n\n", len(failed))
+		for _, roomID := range slices.Sorted(maps.Keys(failed)) {
+			reason := failed[roomID]
+			if reason == "" {
+				reason = "waiting to be protected"
+			}
+			fmt.Fprintf(out, "* [%s](%s): %s\n", roomID, roomID.URI().MatrixToURL(), reason)
+		}
+	}
+}
@@ -45,6 +45,14 @@ func (pe *PolicyEvaluator) GetWatchedLists() []id.RoomID {
 	return pe.watchedListsList
 }
 
+// GetAllWatchedLists returns the metadata of all watched lists in the order they're configured in,
+// including lists that aren't applied.
+func (pe *PolicyEvaluator) GetAllWatchedLists() []*config.WatchedPolicyList {
+	pe.watchedListsLock.RLock()
+	defer pe.watchedListsLock.RUnlock()
+	return pe.watchedListsAll
+}
+
 func (pe *PolicyEvaluator) handleWatchedLists(ctx context.Context, evt *event.Event, isInitial bool) (output, errors []string) {
 	content, ok := evt.Content.Parsed.(*config.WatchedListsEventContent)
 	if !ok {
@@ -57,25 +65,31 @@ func (pe *PolicyEvaluator) handleWatchedLists(ctx context.Context, evt *event.Ev
 		go func() {
 			defer wg.Done()
 			if !pe.Store.Contains(listInfo.RoomID) {
-				state, err := pe.Bot.State(ctx, listInfo.RoomID)
+				err := pe.loadPolicyList(ctx, listInfo.RoomID)
 				if err != nil {
 					outLock.Lock()
 					errors = append(errors, fmt.Sprintf("* Failed to get room state for [%s](%s): %v", listInfo.Name, listInfo.RoomID.URI().MatrixToURL(), err))
 					outLock.Unlock()
 					return
 				}
-				pe.Store.Add(listInfo.RoomID, state)
 			}
 		}()
 	}
 	wg.Wait()
 	watchedList := make([]id.RoomID, 0, len(content.Lists))
 	watchedMap := make(map[id.RoomID]*config.WatchedPolicyList, len(content.Lists))
+	allLists := make([]*config.WatchedPolicyList, 0, len(content.Lists))
 	for _, listInfo := range content.Lists {
+		for _, rule := range listInfo.RedactOnBan {
+			if err := rule.Compile(); err != nil {
+				errors = append(errors, fmt.Sprintf("* Invalid redaction rule `%s` in [%s](%s): %v", rule.Reason, listInfo.Name, listInfo.RoomID.URI().MatrixToURL(), err))
+			}
+		}
 		if _, alreadyWatched := watchedMap[listInfo.RoomID]; alreadyWatched {
 			errors = append(errors, fmt.Sprintf("* Duplicate watched list [%s](%s)", listInfo.Name, listInfo.RoomID.URI().MatrixToURL()))
 		} else {
 			watchedMap[listInfo.RoomID] = &listInfo
+			allLists = append(allLists, &listInfo)
 			if !listInfo.DontApply {
 				watchedList = append(watchedList, listInfo.RoomID)
 			}
@@ -83,8 +97,10 @@ func (pe *PolicyEvaluator) handleWatchedLists(ctx context.Context, evt *event.Ev
 	}
 	pe.watchedListsLock.Lock()
 	oldWatchedList := pe.watchedListsList
+	oldWatchedMap := pe.watchedListsMap
 	pe.watchedListsMap = watchedMap
 	pe.watchedListsList = watchedList
+	pe.watchedListsAll = allLists
 	pe.watchedListsLock.Unlock()
 	if !isInitial {
 		unsubscribed, subscribed := exslices.Diff(oldWatchedList, watchedList)
@@ -94,12 +110,24 @@ func (pe *PolicyEvaluator) handleWatchedLists(ctx context.Context, evt *event.Ev
 		for _, roomID := range unsubscribed {
 			output = append(output, fmt.Sprintf("* Unsubscribed from [%s](%s)", roomID, roomID.URI().MatrixToURL()))
 		}
+		var unwatched []id.RoomID
+		for roomID := range oldWatchedMap {
+			if _, stillWatched := watchedMap[roomID]; !stillWatched {
+				unwatched = append(unwatched, roomID)
+			}
+		}
 		go func(ctx context.Context) {
+			pe.uncachePolicyLists(ctx, unwatched)
 			if len(unsubscribed) > 0 {
-				pe.ReevaluateAffectedByLists(ctx, unsubscribed)
+				unsubscribedMeta := make([]*config.WatchedPolicyList, len(unsubscribed))
+				for i, roomID := range unsubscribed {
+					unsubscribedMeta[i] = oldWatchedMap[roomID]
+				}
+				pe.ReevaluateAffectedByLists(ctx, unsubscribedMeta)
 			}
 			if len(subscribed) > 0 || len(unsubscribed) > 0 {
 				pe.EvaluateAll(ctx)
+				pe.UpdateACL(ctx)
 			}
 		}(context.WithoutCancel(ctx))
 	}
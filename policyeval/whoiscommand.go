@@ -0,0 +1,157 @@
+package policyeval
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/meowlnir/policylist"
+)
+
+const whoisReportLimit = 10
+
+func init() {
+	registerCommands(&CommandHandler{
+		Name:        "whois",
+		Usage:       "<user ID>",
+		Description: "Show everything known about the user: rooms, matching policies, actions taken and reports.",
+		MinArgs:     1,
+		Func:        (*PolicyEvaluator).cmdWhois,
+	})
+}
+
+func formatTime(ts time.Time) string {
+	return ts.UTC().Format("2006-01-02 15:04:05 UTC")
+}
+
+func (pe *PolicyEvaluator) cmdWhois(ctx context.Context, ce *CommandEvent) {
+	userID := id.UserID(ce.Args[0])
+	if _, _, err := userID.Parse(); err != nil {
+		ce.ReplyError(ctx, "`%s` is not a valid user ID: %v", userID, err)
+		return
+	}
+	var out strings.Builder
+	fmt.Fprintf(&out, "**[%s](%s)**\n\n", userID, userID.URI().MatrixToURL())
+
+	rooms := pe.getRoomsUserIsIn(userID)
+	fmt.Fprintf(&out, "**Protected rooms** (%d)\n\n", len(rooms))
+	for _, roomID := range rooms {
+		fmt.Fprintf(&out, "* [%s](%s)\n", roomID, roomID.URI().MatrixToURL())
+	}
+
+	pe.writeWhoisPolicies(&out, userID)
+	pe.writeWhoisActions(ctx, &out, userID)
+	pe.writeWhoisReports(ctx, &out, userID)
+	if userID.Homeserver() == pe.Bot.UserID.Homeserver() {
+		pe.writeWhoisSynapseInfo(ctx, &out, userID)
+	}
+	ce.Reply(ctx, strings.TrimSpace(out.String()))
+}
+
+func (pe *PolicyEvaluator) writeWhoisPolicies(out *strings.Builder, userID id.UserID) {
+	lists := pe.GetAllWatchedLists()
+	listIDs := make([]id.RoomID, len(lists))
+	for i, list := range lists {
+		listIDs[i] = list.RoomID
+	}
+	match := pe.matchEntity(policylist.EntityTypeUser, string(userID), listIDs)
+	fmt.Fprintf(out, "\n**Matching policies** (%d)\n\n", len(match))
+	for _, policy := range match {
+		meta := pe.GetWatchedListMeta(policy.RoomID)
+		var listInfo string
+		if meta != nil {
+			applied := "applied"
+			if meta.DontApply {
+				applied = "not applied"
+			}
+			listInfo = fmt.Sprintf("%s (`%s`, %s)", meta.Name, meta.Shortcode, applied)
+		} else {
+			listInfo = policy.RoomID.String()
+		}
+		fmt.Fprintf(
+			out, "* `%s` for %s `%s` in %s by [%s](%s) at %s: %s\n",
+			policy.Recommendation, policy.EntityType, policy.EntityOrHash(), listInfo,
+			policy.Sender, policy.Sender.URI().MatrixToURL(), formatTime(time.UnixMilli(policy.Timestamp)),
+			policy.Reason,
+		)
+	}
+}
+
+func (pe *PolicyEvaluator) writeWhoisActions(ctx context.Context, out *strings.Builder, userID id.UserID) {
+	actions, err := pe.DB.TakenAction.GetAllForUser(ctx, userID)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to get taken actions for whois")
+		fmt.Fprintf(out, "\n**Actions taken**\n\nFailed to get actions: %v\n", err)
+		return
+	}
+	fmt.Fprintf(out, "\n**Actions taken** (%d)\n\n", len(actions))
+	for _, action := range actions {
+		fmt.Fprintf(
+			out, "* `%s` in [%s](%s) because of %s rule `%s` in %s at %s\n",
+			action.Action, action.InRoomID, action.InRoomID.URI().MatrixToURL(),
+			action.RuleType, action.RuleEntity, pe.listName(action.PolicyList), formatTime(action.TakenAt),
+		)
+	}
+}
+
+func (pe *PolicyEvaluator) writeWhoisReports(ctx context.Context, out *strings.Builder, userID id.UserID) {
+	reports, err := pe.DB.Report.GetRecentByTarget(ctx, pe.ManagementRoom, userID, whoisReportLimit)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to get reports for whois")
+		fmt.Fprintf(out, "\n**Recent reports**\n\nFailed to get reports: %v\n", err)
+		return
+	}
+	fmt.Fprintf(out, "\n**Recent reports** (%d)\n\n", len(reports))
+	for _, report := range reports {
+		fmt.Fprintf(
+			out, "* [%s](%s) reported %s at %s: %s\n",
+			report.Reporter, report.Reporter.URI().MatrixToURL(),
+			formatReportedObject(report), formatTime(report.CreatedAt), report.Reason,
+		)
+	}
+}
+
+func (pe *PolicyEvaluator) writeWhoisSynapseInfo(ctx context.Context, out *strings.Builder, userID id.UserID) {
+	out.WriteString("\n**Local account**\n\n")
+	info, err := pe.SynapseDB.GetUserInfo(ctx, userID)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to get user info from Synapse database for whois")
+		fmt.Fprintf(out, "Failed to get user info: %v\n", err)
+		return
+	} else if info == nil {
+		out.WriteString("User doesn't exist\n")
+		return
+	}
+	if !info.CreatedAt.IsZero() {
+		fmt.Fprintf(out, "* Registered at %s\n", formatTime(info.CreatedAt))
+	}
+	var flags []string
+	if info.Admin {
+		flags = append(flags, "server admin")
+	}
+	if info.Deactivated {
+		flags = append(flags, "deactivated")
+	}
+	if info.ShadowBanned {
+		flags = append(flags, "shadow-banned")
+	}
+	if len(flags) > 0 {
+		fmt.Fprintf(out, "* Flags: %s\n", strings.Join(flags, ", "))
+	}
+	if info.LastSeen.IsZero() {
+		out.WriteString("* Never seen\n")
+	} else {
+		fmt.Fprintf(out, "* Last seen at %s from `%s`", formatTime(info.LastSeen), info.LastSeenIP)
+		if info.LastSeenDeviceID != "" {
+			fmt.Fprintf(out, " on device `%s`", info.LastSeenDeviceID)
+		}
+		if info.LastSeenUserAgent != "" {
+			fmt.Fprintf(out, " (`%s`)", info.LastSeenUserAgent)
+		}
+		out.WriteString("\n")
+	}
+}
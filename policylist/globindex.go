@@ -0,0 +1,148 @@
+package policylist
+
+import (
+	"slices"
+
+	"go.mau.fi/util/glob"
+)
+
+// globIndex indexes the common shapes of glob rules so that they can be matched without scanning every rule.
+//
+// Rules like `*.example.com` and `@*:example.com` are indexed by their suffix, `@spam*` is indexed by its prefix,
+// and `@spam*:example.com` is indexed by both. Matching an entity then only requires looking up each prefix and
+// suffix of the entity in maps, which doesn't depend on the number of rules. Only prefix and suffix lengths
+// that are actually used by some rule are checked.
+type globIndex struct {
+	bySuffix   map[string]*prefixIndex
+	suffixLens map[int]int
+	byPrefix   prefixIndex
+}
+
+type prefixIndex struct {
+	nodes map[string][]*dplNode
+	lens  map[int]int
+}
+
+func newGlobIndex() *globIndex {
+	return &globIndex{
+		bySuffix:   make(map[string]*prefixIndex),
+		suffixLens: make(map[int]int),
+		byPrefix:   newPrefixIndex(),
+	}
+}
+
+func newPrefixIndex() prefixIndex {
+	return prefixIndex{
+		nodes: make(map[string][]*dplNode),
+		lens:  make(map[int]int),
+	}
+}
+
+// splitIndexable returns the prefix and suffix of the given pattern,
+// or false if the pattern can't be represented as a prefix and suffix.
+func splitIndexable(pattern glob.Glob) (prefix, suffix string, isSuffix, ok bool) {
+	switch typedPattern := pattern.(type) {
+	case glob.PrefixGlob:
+		return string(typedPattern), "", false, true
+	case glob.SuffixGlob:
+		return "", string(typedPattern), true, true
+	case glob.PrefixAndSuffixGlob:
+		return typedPattern.Prefix, typedPattern.Suffix, true, true
+	default:
+		return "", "", false, false
+	}
+}
+
+func (pi *prefixIndex) add(prefix string, node *dplNode) {
+	pi.nodes[prefix] = append(pi.nodes[prefix], node)
+	pi.lens[len(prefix)]++
+}
+
+func (pi *prefixIndex) remove(prefix string, node *dplNode) bool {
+	nodes := pi.nodes[prefix]
+	idx := slices.Index(nodes, node)
+	if idx < 0 {
+		return false
+	}
+	if len(nodes) == 1 {
+		delete(pi.nodes, prefix)
+	} else {
+		pi.nodes[prefix] = slices.Delete(nodes, idx, idx+1)
+	}
+	if pi.lens[len(prefix)] <= 1 {
+		delete(pi.lens, len(prefix))
+	} else {
+		pi.lens[len(prefix)]--
+	}
+	return true
+}
+
+// match appends all nodes whose prefix matches the entity to the output.
+// The nodes are in an arbitrary order, List.Match sorts them afterwards.
+func (pi *prefixIndex) match(entity string, output []*dplNode) []*dplNode {
+	for prefixLen := range pi.lens {
+		if prefixLen > len(entity) {
+			continue
+		}
+		output = append(output, pi.nodes[entity[:prefixLen]]...)
+	}
+	return output
+}
+
+// add adds the given node to the index. If the pattern is not indexable, false is returned.
+func (gi *globIndex) add(node *dplNode) bool {
+	prefix, suffix, isSuffix, ok := splitIndexable(node.Pattern)
+	if !ok {
+		return false
+	} else if !isSuffix {
+		gi.byPrefix.add(prefix, node)
+		return true
+	}
+	bucket, ok := gi.bySuffix[suffix]
+	if !ok {
+		newBucket := newPrefixIndex()
+		bucket = &newBucket
+		gi.bySuffix[suffix] = bucket
+	}
+	bucket.add(prefix, node)
+	gi.suffixLens[len(suffix)]++
+	return true
+}
+
+// remove removes the given node from the index. If the node wasn't in the index, this is a no-op.
+func (gi *globIndex) remove(node *dplNode) {
+	prefix, suffix, isSuffix, ok := splitIndexable(node.Pattern)
+	if !ok {
+		return
+	} else if !isSuffix {
+		gi.byPrefix.remove(prefix, node)
+		return
+	}
+	bucket, ok := gi.bySuffix[suffix]
+	if !ok || !bucket.remove(prefix, node) {
+		return
+	}
+	if len(bucket.nodes) == 0 {
+		delete(gi.bySuffix, suffix)
+	}
+	if gi.suffixLens[len(suffix)] <= 1 {
+		delete(gi.suffixLens, len(suffix))
+	} else {
+		gi.suffixLens[len(suffix)]--
+	}
+}
+
+func (gi *globIndex) match(entity string, output []*dplNode) []*dplNode {
+	output = gi.byPrefix.match(entity, output)
+	for suffixLen := range gi.suffixLens {
+		if suffixLen > len(entity) {
+			continue
+		}
+		bucket, ok := gi.bySuffix[entity[len(entity)-suffixLen:]]
+		if ok {
+			// The prefix must not overlap with the suffix, so only pass the part before the suffix
+			output = bucket.match(entity[:len(entity)-suffixLen], output)
+		}
+	}
+	return output
+}
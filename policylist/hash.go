@@ -0,0 +1,29 @@
+package policylist
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+
+	"go.mau.fi/util/glob"
+)
+
+// HashGlob is used as the pattern of hashed policies (MSC4205). It matches entities whose SHA-256 hash equals the hash.
+type HashGlob [32]byte
+
+var _ glob.Glob = HashGlob{}
+
+func (hg HashGlob) Match(entity string) bool {
+	return sha256.Sum256([]byte(entity)) == hg
+}
+
+func parseEntityHash(encoded string) (hash [32]byte, ok bool) {
+	if encoded == "" {
+		return
+	}
+	decoded, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(encoded, "="))
+	if err != nil || len(decoded) != len(hash) {
+		return
+	}
+	return [32]byte(decoded), true
+}
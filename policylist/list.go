@@ -1,6 +1,10 @@
 package policylist
 
 import (
+	"cmp"
+	"crypto/sha256"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
@@ -13,6 +17,9 @@ import (
 
 type dplNode struct {
 	*Policy
+	// seq is incremented for every node added to the list. It's used to keep the order of glob matches stable,
+	// as the glob index doesn't remember the order in which nodes were added.
+	seq  uint64
 	prev *dplNode
 	next *dplNode
 }
@@ -20,12 +27,18 @@ type dplNode struct {
 // List represents the list of rules for a single entity type.
 //
 // Policies are split into literal rules and dynamic rules. Literal rules are stored in a map for fast matching,
-// while dynamic rules are glob patterns and are evaluated one by one for each query.
+// and so are hashed rules (MSC4205), which are keyed by the SHA-256 hash of the entity.
+// Dynamic rules are glob patterns: simple prefix and suffix patterns are stored in an index (see globIndex),
+// while the remaining patterns are evaluated one by one for each query.
 type List struct {
 	matchDuration prometheus.Observer
 	byStateKey    map[string]*dplNode
 	byEntity      map[string]*dplNode
+	byEntityHash  map[[32]byte]*dplNode
+	globIndex     *globIndex
 	dynamicHead   *dplNode
+	expiring      map[*dplNode]struct{}
+	nextSeq       uint64
 	lock          sync.RWMutex
 }
 
@@ -34,6 +47,9 @@ func NewList(roomID id.RoomID, entityType string) *List {
 		matchDuration: matchDuration.WithLabelValues(roomID.String(), entityType),
 		byStateKey:    make(map[string]*dplNode),
 		byEntity:      make(map[string]*dplNode),
+		byEntityHash:  make(map[[32]byte]*dplNode),
+		globIndex:     newGlobIndex(),
+		expiring:      make(map[*dplNode]struct{}),
 	}
 }
 
@@ -50,6 +66,11 @@ func typeQuality(evtType event.Type) int {
 	}
 }
 
+func (l *List) removeDynamic(node *dplNode) {
+	l.globIndex.remove(node)
+	l.removeFromLinkedList(node)
+}
+
 func (l *List) removeFromLinkedList(node *dplNode) {
 	if l.dynamicHead == node {
 		l.dynamicHead = node.next
@@ -70,22 +91,30 @@ func (l *List) Add(value *Policy) (*Policy, bool) {
 		if typeQuality(existing.Type) > typeQuality(value.Type) {
 			// There's an existing policy with the same state key, but a newer event type, ignore this one.
 			return nil, false
-		} else if existing.Entity == value.Entity {
+		} else if existing.Entity == value.Entity && existing.EntityHash == value.EntityHash {
 			oldPolicy := existing.Policy
 			// The entity in the policy didn't change, just update the policy.
 			existing.Policy = value
+			l.updateExpiring(existing)
 			return oldPolicy, true
 		}
 		// There's an existing event with the same state key, but the entity changed, remove the old node.
-		l.removeFromLinkedList(existing)
-		delete(l.byEntity, existing.Entity)
+		l.removeDynamic(existing)
+		l.removeFromEntityMaps(existing)
+		delete(l.expiring, existing)
 	}
-	node := &dplNode{Policy: value}
+	l.nextSeq++
+	node := &dplNode{Policy: value, seq: l.nextSeq}
 	l.byStateKey[value.StateKey] = node
-	if !value.Ignored {
+	l.updateExpiring(node)
+	if value.Ignored {
+		// Ignored policies are only tracked by state key
+	} else if value.IsHashed() {
+		l.byEntityHash[value.EntityHash] = node
+	} else {
 		l.byEntity[value.Entity] = node
 	}
-	if _, isStatic := value.Pattern.(glob.ExactGlob); !isStatic && !value.Ignored {
+	if _, isStatic := value.Pattern.(glob.ExactGlob); !isStatic && !value.IsHashed() && !value.Ignored && !l.globIndex.add(node) {
 		if l.dynamicHead != nil {
 			node.next = l.dynamicHead
 			l.dynamicHead.prev = node
@@ -98,20 +127,104 @@ func (l *List) Add(value *Policy) (*Policy, bool) {
 	return nil, true
 }
 
+func (l *List) updateExpiring(node *dplNode) {
+	if node.Expiry != 0 {
+		l.expiring[node] = struct{}{}
+	} else {
+		delete(l.expiring, node)
+	}
+}
+
 func (l *List) Remove(eventType event.Type, stateKey string) *Policy {
 	l.lock.Lock()
 	defer l.lock.Unlock()
 	if value, ok := l.byStateKey[stateKey]; ok && eventType == value.Type {
-		l.removeFromLinkedList(value)
-		if entValue, ok := l.byEntity[value.Entity]; ok && entValue == value {
-			delete(l.byEntity, value.Entity)
-		}
-		delete(l.byStateKey, stateKey)
+		l.unlockedRemove(value)
 		return value.Policy
 	}
 	return nil
 }
 
+func (l *List) removeFromEntityMaps(node *dplNode) {
+	if node.IsHashed() {
+		if hashValue, ok := l.byEntityHash[node.EntityHash]; ok && hashValue == node {
+			delete(l.byEntityHash, node.EntityHash)
+		}
+	} else if entValue, ok := l.byEntity[node.Entity]; ok && entValue == node {
+		delete(l.byEntity, node.Entity)
+	}
+}
+
+func (l *List) unlockedRemove(node *dplNode) {
+	l.removeDynamic(node)
+	l.removeFromEntityMaps(node)
+	delete(l.byStateKey, node.StateKey)
+	delete(l.expiring, node)
+}
+
+// RemoveExpired removes all policies which have expired before the given time and returns them.
+func (l *List) RemoveExpired(now time.Time) (removed []*Policy) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for node := range l.expiring {
+		if node.IsExpired(now) {
+			l.unlockedRemove(node)
+			removed = append(removed, node.Policy)
+		}
+	}
+	return
+}
+
+// NextExpiry returns the unix millisecond timestamp of the policy that expires next, or zero if no policies expire.
+func (l *List) NextExpiry() (next int64) {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	for node := range l.expiring {
+		if next == 0 || node.Expiry < next {
+			next = node.Expiry
+		}
+	}
+	return
+}
+
+// All returns all policies in the list, including ignored policies.
+func (l *List) All() []*Policy {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	output := make([]*Policy, 0, len(l.byStateKey))
+	for _, node := range l.byStateKey {
+		output = append(output, node.Policy)
+	}
+	return output
+}
+
+// FindByEntity returns all policies whose entity is exactly the given string.
+// Unlike Match, glob patterns are not evaluated, but hashed policies for the entity are included.
+func (l *List) FindByEntity(entity string) (output []*Policy) {
+	entityHash := sha256.Sum256([]byte(entity))
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	for _, node := range l.byStateKey {
+		if node.Entity == entity || (node.IsHashed() && (node.EntityHash == entityHash || node.EntityOrHash() == entity)) {
+			output = append(output, node.Policy)
+		}
+	}
+	return
+}
+
+// Search returns all policies whose entity or reason contains the given text. The search is case-insensitive.
+func (l *List) Search(text string) (output []*Policy) {
+	text = strings.ToLower(text)
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	for _, node := range l.byStateKey {
+		if strings.Contains(strings.ToLower(node.EntityOrHash()), text) || strings.Contains(strings.ToLower(node.Reason), text) {
+			output = append(output, node.Policy)
+		}
+	}
+	return
+}
+
 var matchDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
 	Name: "meowlnir_policylist_match_duration_nanoseconds",
 	Help: "Time taken to evaluate an entity against all policies",
@@ -130,9 +243,32 @@ func (l *List) Match(entity string) (output Match) {
 	if value, ok := l.byEntity[entity]; ok {
 		output = Match{value.Policy}
 	}
+	if len(l.byEntityHash) > 0 {
+		if value, ok := l.byEntityHash[sha256.Sum256([]byte(entity))]; ok {
+			output = append(output, value.Policy)
+		}
+	}
+	dynamic := l.globIndex.match(entity, nil)
 	for item := l.dynamicHead; item != nil; item = item.next {
 		if !item.Ignored && item.Pattern.Match(entity) {
-			output = append(output, item.Policy)
+			dynamic = append(dynamic, item)
+		}
+	}
+	if len(dynamic) > 1 {
+		// Glob rules are matched newest first, like the unindexed linked list
+		slices.SortFunc(dynamic, func(a, b *dplNode) int {
+			return cmp.Compare(b.seq, a.seq)
+		})
+	}
+	for _, node := range dynamic {
+		output = append(output, node.Policy)
+	}
+	if len(l.expiring) > 0 {
+		output = slices.DeleteFunc(output, func(policy *Policy) bool {
+			return policy.IsExpired(start)
+		})
+		if len(output) == 0 {
+			output = nil
 		}
 	}
 	l.matchDuration.Observe(float64(time.Since(start)))
@@ -0,0 +1,151 @@
+package policylist
+
+import (
+	"fmt"
+	"testing"
+
+	"go.mau.fi/util/glob"
+	"maunium.net/go/mautrix/event"
+)
+
+func makeTestPolicy(entity string) *Policy {
+	return &Policy{
+		ModPolicyContent: &event.ModPolicyContent{
+			Entity:         entity,
+			Recommendation: event.PolicyRecommendationBan,
+		},
+		Pattern:    glob.Compile(entity),
+		EntityType: EntityTypeUser,
+		StateKey:   entity,
+		Type:       event.StatePolicyUser,
+	}
+}
+
+func matchEntities(match Match) []string {
+	entities := make([]string, len(match))
+	for i, policy := range match {
+		entities[i] = policy.Entity
+	}
+	return entities
+}
+
+func TestList_Match(t *testing.T) {
+	tests := []struct {
+		name    string
+		add     []string
+		remove  []string
+		entity  string
+		matches []string
+	}{
+		{"Prefix", []string{"@spam*"}, nil, "@spammer:example.com", []string{"@spam*"}},
+		{"PrefixNoMatch", []string{"@spam*"}, nil, "@user:example.com", nil},
+		{"Suffix", []string{"@*:example.com"}, nil, "@user:example.com", []string{"@*:example.com"}},
+		{"SuffixNoMatch", []string{"@*:example.com"}, nil, "@user:example.org", nil},
+		{"PrefixAndSuffix", []string{"@spam*:example.com"}, nil, "@spammer:example.com", []string{"@spam*:example.com"}},
+		{"PrefixAndSuffixWrongSuffix", []string{"@spam*:example.com"}, nil, "@spammer:example.org", nil},
+		{"PrefixAndSuffixWrongPrefix", []string{"@spam*:example.com"}, nil, "@user:example.com", nil},
+		{"PrefixAndSuffixOverlap", []string{"@a*a"}, nil, "@a", nil},
+		{"RemovePrefix", []string{"@spam*"}, []string{"@spam*"}, "@spammer:example.com", nil},
+		{"RemoveSuffix", []string{"@*:example.com"}, []string{"@*:example.com"}, "@user:example.com", nil},
+		{"RemovePrefixAndSuffix", []string{"@spam*:example.com"}, []string{"@spam*:example.com"}, "@spammer:example.com", nil},
+		{
+			"RemoveOneOfSameLength",
+			[]string{"@*:example.com", "@*:example.org"}, []string{"@*:example.org"},
+			"@user:example.com", []string{"@*:example.com"},
+		},
+		{
+			"Unindexed",
+			[]string{"@sp?m*:example.com"}, nil,
+			"@spam:example.com", []string{"@sp?m*:example.com"},
+		},
+		{
+			"ExactFirstThenNewestGlob",
+			[]string{"@spam*", "@spammer:example.com", "@*:example.com", "@sp?mmer:*", "@spam*:example.com"}, nil,
+			"@spammer:example.com", []string{"@spammer:example.com", "@spam*:example.com", "@sp?mmer:*", "@*:example.com", "@spam*"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			list := NewList("!test:example.com", "user")
+			for _, entity := range test.add {
+				list.Add(makeTestPolicy(entity))
+			}
+			for _, entity := range test.remove {
+				if list.Remove(event.StatePolicyUser, entity) == nil {
+					t.Fatalf("Failed to remove %s", entity)
+				}
+			}
+			matches := matchEntities(list.Match(test.entity))
+			if fmt.Sprint(matches) != fmt.Sprint(test.matches) {
+				t.Errorf("Expected %v, got %v", test.matches, matches)
+			}
+		})
+	}
+}
+
+func TestList_Match_StableOrder(t *testing.T) {
+	list := NewList("!test:example.com", "user")
+	var expected []string
+	for i := 0; i < 20; i++ {
+		entity := fmt.Sprintf("@%s*", "spammer"[:i%7+1])
+		if i >= 7 {
+			entity = fmt.Sprintf("@*%d:example.com", i)
+		}
+		list.Add(makeTestPolicy(entity))
+		expected = append([]string{entity}, expected...)
+	}
+	for i := 0; i < 10; i++ {
+		// The matches must always be in the same order, newest first
+		matches := matchEntities(list.Match("@spammer19:example.com"))
+		filtered := make([]string, 0, len(matches))
+		for _, entity := range expected {
+			if glob.Compile(entity).Match("@spammer19:example.com") {
+				filtered = append(filtered, entity)
+			}
+		}
+		if fmt.Sprint(matches) != fmt.Sprint(filtered) {
+			t.Fatalf("Expected %v, got %v", filtered, matches)
+		}
+	}
+}
+
+// BenchmarkListMatch compares matching against 100k rules when the globs can use the index
+// and when they're slightly changed so that they have to be evaluated one by one.
+func BenchmarkListMatch(b *testing.B) {
+	b.Run("Indexed", func(b *testing.B) {
+		benchmarkListMatch(b, "")
+	})
+	b.Run("Unindexed", func(b *testing.B) {
+		benchmarkListMatch(b, "?")
+	})
+}
+
+func benchmarkListMatch(b *testing.B, unindexable string) {
+	const ruleCount = 100_000
+	list := NewList("!bench:example.com", "user")
+	for i := 0; i < ruleCount; i++ {
+		var entity string
+		switch i % 4 {
+		case 0:
+			entity = fmt.Sprintf("@user%d:example.com", i)
+		case 1:
+			entity = fmt.Sprintf("@*:server%d.example%scom", i, unindexable)
+		case 2:
+			entity = fmt.Sprintf("@spam%d%s*", i, unindexable)
+		case 3:
+			entity = fmt.Sprintf("@spam%d*:server.example%scom", i, unindexable)
+		}
+		list.Add(makeTestPolicy(entity))
+	}
+	entities := []string{
+		"@user4:example.com",
+		"@someone:server5.example.com",
+		"@spam6bot:example.org",
+		"@spam7bot:server.example.com",
+		"@innocent:example.net",
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		list.Match(entities[i%len(entities)])
+	}
+}
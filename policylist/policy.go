@@ -1,6 +1,9 @@
 package policylist
 
 import (
+	"encoding/base64"
+	"time"
+
 	"go.mau.fi/util/glob"
 	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/id"
@@ -10,6 +13,8 @@ import (
 type Policy struct {
 	*event.ModPolicyContent
 	Pattern glob.Glob
+	// EntityHash is the SHA-256 hash of the entity for MSC4205 hashed policies, which don't have a plaintext entity.
+	EntityHash [32]byte
 
 	EntityType EntityType
 	RoomID     id.RoomID
@@ -19,6 +24,43 @@ type Policy struct {
 	Timestamp  int64
 	ID         id.EventID
 	Ignored    bool
+	// Expiry is the unix millisecond timestamp after which the policy stops applying, or zero if it never expires.
+	Expiry int64
+}
+
+// ExpiryKey is the key in policy event content which contains the expiry timestamp of the policy.
+const ExpiryKey = "fi.mau.meowlnir.expiry"
+
+// IsHashed returns true if the policy only has a hash of the entity rather than the plaintext entity.
+func (p *Policy) IsHashed() bool {
+	return p.Entity == "" && p.EntityHash != [32]byte{}
+}
+
+// EntityOrHash returns the plaintext entity of the policy, or the base64-encoded hash prefixed with
+// `sha256:` for hashed policies.
+func (p *Policy) EntityOrHash() string {
+	if p.IsHashed() {
+		return "sha256:" + base64.RawStdEncoding.EncodeToString(p.EntityHash[:])
+	}
+	return p.Entity
+}
+
+// IsExpired returns true if the policy has an expiry timestamp and it's before the given time.
+func (p *Policy) IsExpired(now time.Time) bool {
+	return p.Expiry != 0 && p.Expiry <= now.UnixMilli()
+}
+
+// Takedown recommendations from MSC4204. Takedowns are stronger bans that also mean all content from
+// the entity should be removed. They are normalized to the stable identifier when parsing policies.
+const (
+	PolicyRecommendationTakedown         event.PolicyRecommendation = "m.takedown"
+	PolicyRecommendationUnstableTakedown event.PolicyRecommendation = "org.matrix.msc4204.takedown"
+)
+
+// IsBanRecommendation returns true if the given recommendation means the entity should be banned,
+// i.e. if it's either a ban or a takedown.
+func IsBanRecommendation(rec event.PolicyRecommendation) bool {
+	return rec == event.PolicyRecommendationBan || rec == PolicyRecommendationTakedown
 }
 
 // Match represent a list of policies that matched a specific entity.
@@ -29,9 +71,15 @@ type Recommendations struct {
 }
 
 // Recommendations aggregates the recommendations in the match.
+//
+// Takedowns always take priority. Otherwise, the first ban or unban recommendation is used.
 func (m Match) Recommendations() (output Recommendations) {
 	for _, policy := range m {
 		switch policy.Recommendation {
+		case PolicyRecommendationTakedown:
+			if output.BanOrUnban == nil || output.BanOrUnban.Recommendation != PolicyRecommendationTakedown {
+				output.BanOrUnban = policy
+			}
 		case event.PolicyRecommendationBan, event.PolicyRecommendationUnban:
 			if output.BanOrUnban == nil {
 				output.BanOrUnban = policy
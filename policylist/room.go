@@ -1,6 +1,10 @@
 package policylist
 
 import (
+	"bytes"
+	"encoding/json"
+	"time"
+
 	"go.mau.fi/util/glob"
 	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/id"
@@ -43,6 +47,42 @@ func (r *Room) GetServerRules() *List {
 	return r.ServerRules
 }
 
+// NextExpiry returns the unix millisecond timestamp of the policy in this room that expires next,
+// or zero if no policies expire.
+func (r *Room) NextExpiry() (next int64) {
+	for _, list := range []*List{r.UserRules, r.RoomRules, r.ServerRules} {
+		listNext := list.NextExpiry()
+		if listNext != 0 && (next == 0 || listNext < next) {
+			next = listNext
+		}
+	}
+	return
+}
+
+// RemoveExpired removes all policies that have expired before the given time and returns them.
+func (r *Room) RemoveExpired(now time.Time) (removed []*Policy) {
+	removed = append(removed, r.UserRules.RemoveExpired(now)...)
+	removed = append(removed, r.RoomRules.RemoveExpired(now)...)
+	removed = append(removed, r.ServerRules.RemoveExpired(now)...)
+	return
+}
+
+// FindByEntity returns all policies of any entity type in this room whose entity is exactly the given string.
+func (r *Room) FindByEntity(entity string) (output []*Policy) {
+	output = append(output, r.UserRules.FindByEntity(entity)...)
+	output = append(output, r.RoomRules.FindByEntity(entity)...)
+	output = append(output, r.ServerRules.FindByEntity(entity)...)
+	return
+}
+
+// Search returns all policies of any entity type in this room whose entity or reason contains the given text.
+func (r *Room) Search(text string) (output []*Policy) {
+	output = append(output, r.UserRules.Search(text)...)
+	output = append(output, r.RoomRules.Search(text)...)
+	output = append(output, r.ServerRules.Search(text)...)
+	return
+}
+
 type EntityType string
 
 func (et EntityType) EventType() event.Type {
@@ -63,6 +103,18 @@ const (
 	EntityTypeServer EntityType = "server"
 )
 
+// IsPolicyEventType returns true if the given event type is any of the stable, legacy or unstable policy event types.
+func IsPolicyEventType(evtType event.Type) bool {
+	switch evtType {
+	case event.StatePolicyUser, event.StateLegacyPolicyUser, event.StateUnstablePolicyUser,
+		event.StatePolicyRoom, event.StateLegacyPolicyRoom, event.StateUnstablePolicyRoom,
+		event.StatePolicyServer, event.StateLegacyPolicyServer, event.StateUnstablePolicyServer:
+		return true
+	default:
+		return false
+	}
+}
+
 // Update updates the state of this object with the given policy event.
 //
 // It returns the added and removed/replaced policies, if any.
@@ -131,22 +183,61 @@ func (r *Room) massUpdatePolicyList(input map[string]*event.Event, entityType En
 
 var HackyRuleFilter []string
 
+// extraPolicyContent contains fields of policy events that aren't in [event.ModPolicyContent].
+type extraPolicyContent struct {
+	Expiry int64             `json:"fi.mau.meowlnir.expiry"`
+	Hashes map[string]string `json:"hashes"`
+}
+
+func parseExtraContent(content *event.Content) (output extraPolicyContent) {
+	if content.VeryRaw != nil {
+		// Most policies don't have any extra fields, so check for the keys before parsing the JSON again
+		if bytes.Contains(content.VeryRaw, []byte(ExpiryKey)) || bytes.Contains(content.VeryRaw, []byte(`"hashes"`)) {
+			_ = json.Unmarshal(content.VeryRaw, &output)
+		}
+		return
+	}
+	expiry, _ := content.Raw[ExpiryKey].(float64)
+	output.Expiry = int64(expiry)
+	if hashes, ok := content.Raw["hashes"].(map[string]any); ok {
+		if sha256Hash, ok := hashes["sha256"].(string); ok {
+			output.Hashes = map[string]string{"sha256": sha256Hash}
+		}
+	}
+	return
+}
+
 func (r *Room) updatePolicyList(evt *event.Event, entityType EntityType, rules *List) (added, removed *Policy) {
 	content, ok := evt.Content.Parsed.(*event.ModPolicyContent)
 	if !ok || evt.StateKey == nil {
 		return
 	}
 	r.byEventID[evt.ID] = typeStateKeyTuple{Type: evt.Type, StateKey: *evt.StateKey}
-	if content.Entity == "" || content.Recommendation == "" {
+	extra := parseExtraContent(&evt.Content)
+	var entityHash [32]byte
+	var hasHash bool
+	if content.Entity == "" {
+		entityHash, hasHash = parseEntityHash(extra.Hashes["sha256"])
+	}
+	if (content.Entity == "" && !hasHash) || content.Recommendation == "" {
 		removed = rules.Remove(evt.Type, *evt.StateKey)
 		return
 	}
 	if content.Recommendation == event.PolicyRecommendationUnstableBan {
 		content.Recommendation = event.PolicyRecommendationBan
+	} else if content.Recommendation == PolicyRecommendationUnstableTakedown {
+		content.Recommendation = PolicyRecommendationTakedown
+	}
+	var pattern glob.Glob
+	if hasHash {
+		pattern = HashGlob(entityHash)
+	} else {
+		pattern = glob.Compile(content.Entity)
 	}
 	added = &Policy{
 		ModPolicyContent: content,
-		Pattern:          glob.Compile(content.Entity),
+		Pattern:          pattern,
+		EntityHash:       entityHash,
 
 		EntityType: entityType,
 		RoomID:     evt.RoomID,
@@ -155,8 +246,15 @@ func (r *Room) updatePolicyList(evt *event.Event, entityType EntityType, rules *
 		Type:       evt.Type,
 		Timestamp:  evt.Timestamp,
 		ID:         evt.ID,
+		Expiry:     extra.Expiry,
+	}
+	if added.IsExpired(time.Now()) {
+		// Policies that have already expired are treated the same way as removed policies
+		added = nil
+		removed = rules.Remove(evt.Type, *evt.StateKey)
+		return
 	}
-	if added.Recommendation == event.PolicyRecommendationBan {
+	if IsBanRecommendation(added.Recommendation) {
 		for _, entry := range HackyRuleFilter {
 			if added.Pattern.Match(entry) {
 				added.Ignored = true
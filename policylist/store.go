@@ -4,6 +4,7 @@ import (
 	"maps"
 	"slices"
 	"sync"
+	"time"
 
 	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/id"
@@ -12,14 +13,16 @@ import (
 // Store is a collection of policy rooms that allows matching users, rooms, and servers
 // against the policies of any subset of rooms in the store.
 type Store struct {
-	rooms     map[id.RoomID]*Room
-	roomsLock sync.RWMutex
+	rooms         map[id.RoomID]*Room
+	roomsLock     sync.RWMutex
+	expiryUpdated chan struct{}
 }
 
 // NewStore creates a new policy list store.
 func NewStore() *Store {
 	return &Store{
-		rooms: make(map[id.RoomID]*Room),
+		rooms:         make(map[id.RoomID]*Room),
+		expiryUpdated: make(chan struct{}, 1),
 	}
 }
 
@@ -49,12 +52,7 @@ func (s *Store) MatchServer(listIDs []id.RoomID, serverName string) Match {
 //
 // The added and removed/replaced policies (if any) are returned
 func (s *Store) Update(evt *event.Event) (added, removed *Policy) {
-	switch evt.Type {
-	case event.StatePolicyUser, event.StateLegacyPolicyUser, event.StateUnstablePolicyUser,
-		event.StatePolicyRoom, event.StateLegacyPolicyRoom, event.StateUnstablePolicyRoom,
-		event.StatePolicyServer, event.StateLegacyPolicyServer, event.StateUnstablePolicyServer,
-		event.EventRedaction:
-	default:
+	if !IsPolicyEventType(evt.Type) && evt.Type != event.EventRedaction {
 		return
 	}
 	s.roomsLock.RLock()
@@ -63,7 +61,11 @@ func (s *Store) Update(evt *event.Event) (added, removed *Policy) {
 	if !ok {
 		return
 	}
-	return list.Update(evt)
+	added, removed = list.Update(evt)
+	if added != nil && added.Expiry != 0 {
+		s.notifyExpiryUpdated()
+	}
+	return
 }
 
 // Add adds a room to the store with the given state.
@@ -72,9 +74,100 @@ func (s *Store) Update(evt *event.Event) (added, removed *Policy) {
 //
 // To ensure the store doesn't contain partial state, the store is locked for the duration of the parsing.
 func (s *Store) Add(roomID id.RoomID, state map[event.Type]map[string]*event.Event) {
+	room := NewRoom(roomID).ParseState(state)
 	s.roomsLock.Lock()
-	s.rooms[roomID] = NewRoom(roomID).ParseState(state)
+	s.rooms[roomID] = room
 	s.roomsLock.Unlock()
+	if room.NextExpiry() != 0 {
+		s.notifyExpiryUpdated()
+	}
+}
+
+func (s *Store) notifyExpiryUpdated() {
+	select {
+	case s.expiryUpdated <- struct{}{}:
+	default:
+	}
+}
+
+// ExpiryUpdated returns a channel that receives a value whenever a policy with an expiry is added to the store.
+func (s *Store) ExpiryUpdated() <-chan struct{} {
+	return s.expiryUpdated
+}
+
+// NextExpiry returns the time when the next policy in any room expires, or a zero time if no policies expire.
+func (s *Store) NextExpiry() time.Time {
+	s.roomsLock.RLock()
+	defer s.roomsLock.RUnlock()
+	var next int64
+	for _, room := range s.rooms {
+		roomNext := room.NextExpiry()
+		if roomNext != 0 && (next == 0 || roomNext < next) {
+			next = roomNext
+		}
+	}
+	if next == 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(next)
+}
+
+// RemoveExpired removes all policies that have expired before the given time from all rooms and returns them.
+func (s *Store) RemoveExpired(now time.Time) (removed []*Policy) {
+	s.roomsLock.RLock()
+	defer s.roomsLock.RUnlock()
+	for _, room := range s.rooms {
+		removed = append(removed, room.RemoveExpired(now)...)
+	}
+	return
+}
+
+// ListServerRules returns all server rules in the given policy rooms, keyed by entity.
+//
+// If multiple rooms have a rule for the same entity, the rule from the room that comes first in the list is used.
+func (s *Store) ListServerRules(listIDs []id.RoomID) map[string]*Policy {
+	output := make(map[string]*Policy)
+	now := time.Now()
+	for _, roomID := range listIDs {
+		s.roomsLock.RLock()
+		list, ok := s.rooms[roomID]
+		s.roomsLock.RUnlock()
+		if !ok {
+			continue
+		}
+		list.ServerRules.lock.RLock()
+		for entity, node := range list.ServerRules.byEntity {
+			if node.IsExpired(now) {
+				continue
+			}
+			// Takedowns take priority over other rules, like in Match.Recommendations
+			if existing, alreadySet := output[entity]; !alreadySet ||
+				(node.Recommendation == PolicyRecommendationTakedown && existing.Recommendation != PolicyRecommendationTakedown) {
+				output[entity] = node.Policy
+			}
+		}
+		list.ServerRules.lock.RUnlock()
+	}
+	return output
+}
+
+// GetRoom returns the policy room with the given ID, or nil if it's not in the store.
+func (s *Store) GetRoom(roomID id.RoomID) *Room {
+	s.roomsLock.RLock()
+	room := s.rooms[roomID]
+	s.roomsLock.RUnlock()
+	return room
+}
+
+// Search returns all policies in all rooms in the store whose entity or reason contains the given text.
+func (s *Store) Search(text string) (output []*Policy) {
+	s.roomsLock.RLock()
+	rooms := slices.Collect(maps.Values(s.rooms))
+	s.roomsLock.RUnlock()
+	for _, room := range rooms {
+		output = append(output, room.Search(text)...)
+	}
+	return
 }
 
 func (s *Store) Contains(roomID id.RoomID) bool {
@@ -2,6 +2,8 @@ package synapsedb
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"time"
 
 	"github.com/lib/pq"
@@ -14,10 +16,15 @@ import (
 
 type SynapseDB struct {
 	DB *dbutil.Database
+
+	schemaVersion int
 }
 
 const PreferredVersion = 86
 
+// shadowBanVersion is the schema version where the shadow_banned column was added to the users table.
+const shadowBanVersion = 58
+
 func (s *SynapseDB) CheckVersion(ctx context.Context) error {
 	var current, compat int
 	err := s.DB.QueryRow(ctx, "SELECT version FROM schema_version").Scan(&current)
@@ -28,6 +35,7 @@ func (s *SynapseDB) CheckVersion(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
+	s.schemaVersion = current
 	if current < PreferredVersion {
 		zerolog.Ctx(ctx).Warn().
 			Int("preferred_version", PreferredVersion).
@@ -48,7 +56,7 @@ const getUnredactedEventsBySenderInRoomQuery = `
 	SELECT events.room_id, events.event_id, events.origin_server_ts
 	FROM events
 	LEFT JOIN redactions ON events.event_id=redactions.redacts
-	WHERE events.sender = $1 AND events.room_id = ANY($2) AND redactions.redacts IS NULL
+	WHERE events.sender = $1 AND events.room_id = ANY($2) AND events.origin_server_ts >= $3 AND redactions.redacts IS NULL
 `
 
 const getEventQuery = `
@@ -69,11 +77,16 @@ var scanRoomEventTuple = dbutil.ConvertRowFn[roomEventTuple](func(row dbutil.Sca
 	return
 })
 
-func (s *SynapseDB) GetEventsToRedact(ctx context.Context, sender id.UserID, inRooms []id.RoomID) (map[id.RoomID][]id.EventID, time.Time, error) {
+// GetEventsToRedact finds all unredacted events sent by the given user in the given rooms.
+// If since is non-zero, only events sent after that time are returned.
+func (s *SynapseDB) GetEventsToRedact(ctx context.Context, sender id.UserID, inRooms []id.RoomID, since time.Time) (map[id.RoomID][]id.EventID, time.Time, error) {
 	output := make(map[id.RoomID][]id.EventID)
-	var maxTSRaw int64
+	var maxTSRaw, sinceTS int64
+	if !since.IsZero() {
+		sinceTS = since.UnixMilli()
+	}
 	err := scanRoomEventTuple.NewRowIter(
-		s.DB.Query(ctx, getUnredactedEventsBySenderInRoomQuery, sender, pq.Array(exslices.CastToString[string](inRooms))),
+		s.DB.Query(ctx, getUnredactedEventsBySenderInRoomQuery, sender, pq.Array(exslices.CastToString[string](inRooms)), sinceTS),
 	).Iter(func(tuple roomEventTuple) (bool, error) {
 		output[tuple.RoomID] = append(output[tuple.RoomID], tuple.EventID)
 		maxTSRaw = max(maxTSRaw, tuple.Timestamp)
@@ -90,6 +103,74 @@ func (s *SynapseDB) GetEvent(ctx context.Context, eventID id.EventID) (*event.Ev
 		Scan(&evt.RoomID, &evt.Sender, &evt.Type, &evt.StateKey, &evt.Timestamp, dbutil.JSON{Data: &evt}))
 }
 
+const getUserInfoQuery = `
+	SELECT creation_ts, admin, deactivated, COALESCE(shadow_banned, false)
+	FROM users
+	WHERE name = $1
+`
+
+const getUserInfoQueryNoShadowBan = `
+	SELECT creation_ts, admin, deactivated, false
+	FROM users
+	WHERE name = $1
+`
+
+const getUserLastSeenQuery = `
+	SELECT ip, user_agent, device_id, last_seen
+	FROM user_ips
+	WHERE user_id = $1
+	ORDER BY last_seen DESC
+	LIMIT 1
+`
+
+// UserInfo contains information about a local user from the Synapse database.
+type UserInfo struct {
+	CreatedAt    time.Time
+	Admin        bool
+	Deactivated  bool
+	ShadowBanned bool
+
+	LastSeen          time.Time
+	LastSeenIP        string
+	LastSeenUserAgent string
+	LastSeenDeviceID  id.DeviceID
+}
+
+// GetUserInfo returns the registration and last seen info of the given local user, or nil if the user doesn't exist.
+func (s *SynapseDB) GetUserInfo(ctx context.Context, userID id.UserID) (*UserInfo, error) {
+	var info UserInfo
+	var createdAt sql.NullInt64
+	var admin, deactivated int
+	query := getUserInfoQuery
+	if s.schemaVersion < shadowBanVersion {
+		// Older Synapse versions don't have the shadow_banned column
+		query = getUserInfoQueryNoShadowBan
+	}
+	err := s.DB.QueryRow(ctx, query, userID).Scan(&createdAt, &admin, &deactivated, &info.ShadowBanned)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if createdAt.Valid {
+		info.CreatedAt = time.Unix(createdAt.Int64, 0)
+	}
+	info.Admin = admin != 0
+	info.Deactivated = deactivated != 0
+	var lastSeen int64
+	var userAgent, deviceID sql.NullString
+	err = s.DB.QueryRow(ctx, getUserLastSeenQuery, userID).Scan(&info.LastSeenIP, &userAgent, &deviceID, &lastSeen)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &info, nil
+	} else if err != nil {
+		return nil, err
+	}
+	info.LastSeen = time.UnixMilli(lastSeen)
+	info.LastSeenUserAgent = userAgent.String
+	info.LastSeenDeviceID = id.DeviceID(deviceID.String)
+	return &info, nil
+}
+
 func (s *SynapseDB) Close() error {
 	return s.DB.Close()
 }